@@ -14,34 +14,290 @@ func CreateSchema(db *sql.DB) error {
 		first_name VARCHAR(50) NOT NULL,
 		last_name VARCHAR(50) NOT NULL,
 		created_at TIMESTAMP DEFAULT NOW(),
-		logged_in_at TIMESTAMP DEFAULT NOW()
+		logged_in_at TIMESTAMP DEFAULT NOW(),
+		email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+		pending_email VARCHAR(255),
+		deactivated_at TIMESTAMP,
+		sessions_revoked_at TIMESTAMP,
+		placeholder BOOLEAN NOT NULL DEFAULT FALSE
 	);
 
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_placeholder ON users(placeholder) WHERE placeholder;
+
+	CREATE TABLE IF NOT EXISTS roles (
+		name VARCHAR(20) PRIMARY KEY,
+		description TEXT NOT NULL,
+		rank INTEGER NOT NULL
+	);
+
+	INSERT INTO roles (name, description, rank) VALUES
+		('admin', 'Can manage every task, user and role', 1),
+		('member', 'Can create tasks and manage the ones they own or are assigned to', 2),
+		('viewer', 'Read-only access', 3)
+	ON CONFLICT (name) DO NOTHING;
+
+	CREATE TABLE IF NOT EXISTS organizations (
+		org_id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		created_by INTEGER NOT NULL REFERENCES users(user_id),
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS org_members (
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+		role VARCHAR(20) NOT NULL REFERENCES roles(name),
+		joined_at TIMESTAMP DEFAULT NOW(),
+		suspended_at TIMESTAMP,
+		PRIMARY KEY (org_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_org_members_user ON org_members(user_id);
+
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key VARCHAR(255) PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		allowed BOOLEAN NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer VARCHAR(255) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+		email VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (issuer, subject)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+	CREATE TABLE IF NOT EXISTS auth_events (
+		event_id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+		org_id INTEGER REFERENCES organizations(org_id) ON DELETE SET NULL,
+		email VARCHAR(255) NOT NULL,
+		event_type VARCHAR(20) NOT NULL,
+		method VARCHAR(20) NOT NULL,
+		success BOOLEAN NOT NULL,
+		reason VARCHAR(50),
+		ip VARCHAR(45),
+		user_agent VARCHAR(512),
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_auth_events_user ON auth_events(user_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_auth_events_created ON auth_events(created_at);
+	CREATE INDEX IF NOT EXISTS idx_auth_events_org ON auth_events(org_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		scope VARCHAR(10) NOT NULL,
+		key VARCHAR(255) NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		locked_until TIMESTAMP,
+		PRIMARY KEY (scope, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS projects (
+		project_id SERIAL PRIMARY KEY,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		key VARCHAR(10) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		owner_id INTEGER NOT NULL REFERENCES users(user_id),
+		archived BOOLEAN NOT NULL DEFAULT FALSE,
+		task_seq INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE (org_id, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS invitations (
+		invitation_id SERIAL PRIMARY KEY,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		project_id INTEGER REFERENCES projects(project_id) ON DELETE SET NULL,
+		email VARCHAR(255) NOT NULL,
+		role VARCHAR(20) NOT NULL REFERENCES roles(name),
+		invited_by INTEGER NOT NULL REFERENCES users(user_id),
+		nonce VARCHAR(64) NOT NULL,
+		sent_count INTEGER NOT NULL DEFAULT 1,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		accepted_by INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_invitations_org ON invitations(org_id);
+
 	CREATE TYPE task_status AS ENUM ('ToDo', 'InProgress', 'Done');
 
 	CREATE TYPE priority_en AS ENUM ('High', 'Medium', 'Low');
 
 	CREATE TABLE IF NOT EXISTS tasks (
 		task_id SERIAL PRIMARY KEY,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		project_id INTEGER REFERENCES projects(project_id),
+		task_key VARCHAR(32),
 		title VARCHAR(255) NOT NULL,
 		priority priority_en NOT NULL DEFAULT 'Medium',
 		status task_status NOT NULL DEFAULT 'ToDo',
 		assigned_to INTEGER REFERENCES users(user_id),
 		description TEXT,
+		due_date DATE,
+		estimate_points REAL,
+		estimate_hours REAL,
+		estimate_confidence REAL,
+		estimate_source VARCHAR(10),
 		created_by INTEGER NOT NULL REFERENCES users(user_id),
 		created_at TIMESTAMP DEFAULT NOW(),
 		updated_at TIMESTAMP DEFAULT NOW()
 	);
 
+	CREATE INDEX IF NOT EXISTS idx_tasks_org ON tasks(org_id);
+	CREATE INDEX IF NOT EXISTS idx_tasks_project ON tasks(project_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_key ON tasks(org_id, task_key);
+
+	CREATE TABLE IF NOT EXISTS task_updates (
+		update_id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(task_id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(user_id),
+		change_type VARCHAR(20) NOT NULL,
+		old_assignee INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+		new_assignee INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+		old_status task_status,
+		new_status task_status,
+		old_priority priority_en,
+		new_priority priority_en,
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_updates_task ON task_updates(task_id, updated_at);
+
+	CREATE TABLE IF NOT EXISTS ai_usage (
+		usage_id BIGSERIAL PRIMARY KEY,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(user_id),
+		task_id INTEGER REFERENCES tasks(task_id) ON DELETE SET NULL,
+		feature VARCHAR(30) NOT NULL,
+		model VARCHAR(100) NOT NULL,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		cost_usd NUMERIC(12, 6) NOT NULL DEFAULT 0,
+		success BOOLEAN NOT NULL,
+		redactions INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_ai_usage_org_created ON ai_usage(org_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS ai_quotas (
+		org_id INTEGER PRIMARY KEY REFERENCES organizations(org_id) ON DELETE CASCADE,
+		monthly_tokens_per_user INTEGER NOT NULL,
+		monthly_tokens_per_org INTEGER NOT NULL,
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS ai_redaction_rules (
+		rule_id SERIAL PRIMARY KEY,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		pattern TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS ai_prompt_versions (
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		feature VARCHAR(50) NOT NULL,
+		version VARCHAR(50) NOT NULL,
+		updated_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (org_id, feature)
+	);
+
 	CREATE TABLE IF NOT EXISTS task_suggestions (
 		suggestion_id SERIAL PRIMARY KEY,
-		task_id INTEGER REFERENCES tasks(task_id),
+		task_id INTEGER REFERENCES tasks(task_id) ON DELETE CASCADE,
 		user_id INTEGER NOT NULL REFERENCES users(user_id),
 		suggestion_text TEXT NOT NULL,
 		sub_tasks TEXT NOT NULL,
+		prompt_version VARCHAR(50),
 		created_at TIMESTAMP DEFAULT NOW(),
 		accepted BOOLEAN DEFAULT FALSE
 	);
+
+	CREATE TABLE IF NOT EXISTS analysis_cache (
+		task_id INTEGER NOT NULL REFERENCES tasks(task_id) ON DELETE CASCADE,
+		content_hash CHAR(64) NOT NULL,
+		prompt_version VARCHAR(50) NOT NULL,
+		model VARCHAR(100) NOT NULL,
+		response JSONB NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		expires_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (task_id, content_hash, prompt_version, model)
+	);
+
+	CREATE TABLE IF NOT EXISTS task_embeddings (
+		task_id INTEGER PRIMARY KEY REFERENCES tasks(task_id) ON DELETE CASCADE,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		model VARCHAR(100) NOT NULL,
+		embedding REAL[] NOT NULL,
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_embeddings_org ON task_embeddings(org_id, model);
+
+	CREATE TABLE IF NOT EXISTS task_recommendations (
+		recommendation_id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(task_id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(user_id),
+		priority priority_en NOT NULL,
+		assignee_id INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+		fields TEXT[] NOT NULL DEFAULT '{priority,assignee}',
+		rationale TEXT NOT NULL,
+		source VARCHAR(20) NOT NULL,
+		accepted BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_recommendations_task ON task_recommendations(task_id);
+
+	CREATE TABLE IF NOT EXISTS project_summaries (
+		project_id INTEGER NOT NULL REFERENCES projects(project_id) ON DELETE CASCADE,
+		input_hash CHAR(64) NOT NULL,
+		model VARCHAR(100) NOT NULL,
+		summary TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (project_id, input_hash)
+	);
+
+	CREATE TABLE IF NOT EXISTS digest_runs (
+		digest_date DATE PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		job_id BIGSERIAL PRIMARY KEY,
+		kind VARCHAR(50) NOT NULL,
+		org_id INTEGER NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+		task_id INTEGER REFERENCES tasks(task_id) ON DELETE CASCADE,
+		payload JSONB NOT NULL DEFAULT '{}',
+		status VARCHAR(20) NOT NULL DEFAULT 'queued',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 3,
+		run_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		locked_at TIMESTAMP,
+		last_error TEXT,
+		result JSONB,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW(),
+		finished_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(status, run_at);
+	CREATE INDEX IF NOT EXISTS idx_jobs_task ON jobs(kind, task_id) WHERE status IN ('queued', 'running');
 	`
 
 	_, err := db.Exec(schema)
@@ -0,0 +1,184 @@
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ErrNoResponse means the model returned no text at all, e.g. because the
+// answer was blocked.
+var ErrNoResponse = errors.New("no response from model")
+
+// ProviderError wraps a failed call to the model provider.
+type ProviderError struct {
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("model request failed: %v", e.Err)
+}
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// ParseError means the model's answer did not contain a JSON breakdown.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("model returned invalid JSON: %v", e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ValidationError means the breakdown parsed but broke one of its rules.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "model output failed validation: " + strings.Join(e.Problems, "; ")
+}
+
+// IsInvalidOutput reports whether err means the model answered but the
+// answer was unusable, as opposed to the model not being reached.
+func IsInvalidOutput(err error) bool {
+	var parseErr *ParseError
+	var validationErr *ValidationError
+	return errors.Is(err, ErrNoResponse) || errors.As(err, &parseErr) || errors.As(err, &validationErr)
+}
+
+// breakdownSchema constrains the model's answer to the shape of
+// types.AITaskBreakdownResponse.
+var breakdownSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"analysis": {Type: genai.TypeString},
+		"suggestions": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"suggestion_text": {Type: genai.TypeString},
+					"sub_tasks": {
+						Type: genai.TypeArray,
+						Items: &genai.Schema{
+							Type: genai.TypeObject,
+							Properties: map[string]*genai.Schema{
+								"title":       {Type: genai.TypeString},
+								"description": {Type: genai.TypeString},
+								"priority": {
+									Type: genai.TypeString,
+									Enum: []string{string(types.High), string(types.Medium), string(types.Low)},
+								},
+								"complexity": {
+									Type: genai.TypeString,
+									Enum: []string{string(types.High), string(types.Medium), string(types.Low)},
+								},
+							},
+							Required: []string{"title", "description", "priority", "complexity"},
+						},
+					},
+				},
+				Required: []string{"suggestion_text", "sub_tasks"},
+			},
+		},
+	},
+	Required: []string{"analysis", "suggestions"},
+}
+
+// decodeJSONAnswer pulls the JSON object out of the model's answer, which
+// may be wrapped in a Markdown code block or surrounded by prose, into v.
+func decodeJSONAnswer(text string, v interface{}) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrNoResponse
+	}
+
+	start := strings.Index(text, "{")
+	if start < 0 {
+		return &ParseError{Err: errors.New("no JSON object found")}
+	}
+
+	// The decoder stops after the first complete value, so a closing code
+	// fence or trailing commentary doesn't matter
+	if err := json.NewDecoder(strings.NewReader(text[start:])).Decode(v); err != nil {
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+// parseBreakdown decodes the model's answer and checks it.
+func parseBreakdown(text string) (*types.AITaskBreakdownResponse, error) {
+	var aiResp types.AITaskBreakdownResponse
+	if err := decodeJSONAnswer(text, &aiResp); err != nil {
+		return nil, err
+	}
+	if err := validateBreakdown(&aiResp); err != nil {
+		return nil, err
+	}
+	return &aiResp, nil
+}
+
+// validateBreakdown checks the parts of the answer that get stored: every
+// subtask needs a title and one of the task priorities, and a complexity,
+// if given, uses the same levels. Both are matched case-insensitively and
+// normalized.
+func validateBreakdown(aiResp *types.AITaskBreakdownResponse) error {
+	var problems []string
+	if len(aiResp.Suggestions) == 0 {
+		problems = append(problems, "no suggestions")
+	}
+
+	for i := range aiResp.Suggestions {
+		suggestion := &aiResp.Suggestions[i]
+		if len(suggestion.SubTasks) == 0 {
+			problems = append(problems, fmt.Sprintf("suggestions[%d] has no sub_tasks", i))
+		}
+		for j := range suggestion.SubTasks {
+			subTask := &suggestion.SubTasks[j]
+			subTask.Title = strings.TrimSpace(subTask.Title)
+			if subTask.Title == "" {
+				problems = append(problems, fmt.Sprintf("suggestions[%d].sub_tasks[%d].title is empty", i, j))
+			}
+			subTask.Priority = normalizePriority(subTask.Priority)
+			if !subTask.Priority.IsValid() {
+				problems = append(problems, fmt.Sprintf("suggestions[%d].sub_tasks[%d].priority %q is not High, Medium or Low", i, j, subTask.Priority))
+			}
+			complexity := normalizePriority(types.TaskPriority(subTask.Complexity))
+			if complexity != "" && !complexity.IsValid() {
+				problems = append(problems, fmt.Sprintf("suggestions[%d].sub_tasks[%d].complexity %q is not High, Medium or Low", i, j, subTask.Complexity))
+			}
+			subTask.Complexity = string(complexity)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func normalizePriority(p types.TaskPriority) types.TaskPriority {
+	for _, valid := range []types.TaskPriority{types.High, types.Medium, types.Low} {
+		if strings.EqualFold(strings.TrimSpace(string(p)), string(valid)) {
+			return valid
+		}
+	}
+	return p
+}
+
+// repairPrompt asks the model to fix an answer that failed to parse or
+// validate. The error lists what was wrong.
+func repairPrompt(answer string, err error) string {
+	return fmt.Sprintf(`Your previous answer could not be used: %v
+
+Previous answer:
+%s
+
+Reply again with only the corrected JSON object, following the structure and rules requested above.`, err, answer)
+}
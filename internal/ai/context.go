@@ -0,0 +1,130 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// defaultContextTokens bounds the extra context added to an analysis prompt.
+const defaultContextTokens = 2000
+
+// maxContextLine caps each task listed in the context so a single long
+// description can't use up the budget.
+const maxContextLine = 300
+
+// TaskContext is background for an analysis, so the breakdown fits around
+// work that already exists instead of duplicating it.
+type TaskContext struct {
+	// Notes is free-form background from the requester
+	Notes string
+	// ExistingSubTasks were suggested for the task before
+	ExistingSubTasks []types.Task
+	// OpenTasks are the project's other unfinished tasks
+	OpenTasks []types.Task
+	// CompletedTasks are recently finished tasks similar to this one
+	CompletedTasks []types.Task
+}
+
+// ContextTokenBudget is the number of tokens the context may add to a
+// prompt, AI_CONTEXT_TOKEN_BUDGET or 2000.
+func ContextTokenBudget() int {
+	return envTokens("AI_CONTEXT_TOKEN_BUDGET", defaultContextTokens)
+}
+
+// estimateTokens approximates the token count of text at four characters
+// per token, close enough for budgeting.
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// contextBudget hands out tokens to prompt sections until it runs out.
+type contextBudget struct {
+	remaining int
+}
+
+// take reports whether text still fits and charges it to the budget.
+func (b *contextBudget) take(text string) bool {
+	cost := estimateTokens(text)
+	if cost > b.remaining {
+		return false
+	}
+	b.remaining -= cost
+	return true
+}
+
+// renderContext writes the sections of tc in order of importance: the
+// requester's notes, then existing subtasks, open tasks and completed
+// tasks, each item added only while it fits in budget tokens.
+func renderContext(tc TaskContext, budget int) string {
+	b := &contextBudget{remaining: budget}
+	var out strings.Builder
+
+	if notes := strings.TrimSpace(tc.Notes); notes != "" {
+		section := "\n\nBackground from the requester:\n"
+		maxChars := (b.remaining - estimateTokens(section)) * 4
+		if maxChars > 0 {
+			notes = truncate(notes, maxChars)
+			if b.take(section + notes) {
+				out.WriteString(section + notes)
+			}
+		}
+	}
+
+	writeSection(&out, b, "Subtasks already suggested for this task (do not suggest them again):", tc.ExistingSubTasks,
+		func(t types.Task) string {
+			return fmt.Sprintf("- %s (%s)", t.Title, t.Priority)
+		})
+	writeSection(&out, b, "Other open tasks in the project (do not duplicate their work):", tc.OpenTasks,
+		func(t types.Task) string {
+			return fmt.Sprintf("- %s%s [%s, %s]", keyPrefix(t), t.Title, t.Status, t.Priority)
+		})
+	writeSection(&out, b, "Recently completed similar tasks (for reference):", tc.CompletedTasks,
+		func(t types.Task) string {
+			line := "- " + keyPrefix(t) + t.Title
+			if t.Description != "" {
+				line += ": " + strings.Join(strings.Fields(t.Description), " ")
+			}
+			return line
+		})
+
+	return out.String()
+}
+
+func writeSection(out *strings.Builder, b *contextBudget, heading string, tasks []types.Task, line func(types.Task) string) {
+	header := "\n\n" + heading
+	wroteHeader := false
+	for _, t := range tasks {
+		text := "\n" + truncate(line(t), maxContextLine)
+		if !wroteHeader {
+			if !b.take(header + text) {
+				return
+			}
+			out.WriteString(header)
+			wroteHeader = true
+		} else if !b.take(text) {
+			return
+		}
+		out.WriteString(text)
+	}
+}
+
+func keyPrefix(t types.Task) string {
+	if t.Key == "" {
+		return ""
+	}
+	return t.Key + " "
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	// Cut on a rune boundary
+	cut := max - 3
+	for cut > 0 && cut < len(s) && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + "..."
+}
@@ -0,0 +1,209 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// Embedder turns text into a vector whose cosine similarity to another
+// text's vector says how alike they are. Vectors from different embedders
+// can't be compared, so they are stored with the embedder's Name.
+type Embedder interface {
+	Name() string
+	// Remote embedders call a model, so their use counts against the AI
+	// quota and is recorded like other model calls
+	Remote() bool
+	// DuplicateThreshold is the similarity above which two texts likely
+	// describe the same task; it depends on how the embedder scores
+	DuplicateThreshold() float64
+	Embed(ctx context.Context, text string) ([]float32, Usage, error)
+}
+
+// hashDimensions is the size of HashingEmbedder vectors.
+const hashDimensions = 512
+
+// HashingEmbedder embeds text locally by hashing its words and word pairs
+// into a fixed-size vector. It only captures shared wording, not meaning,
+// but needs no network and is good at spotting the same bug filed twice.
+type HashingEmbedder struct{}
+
+func (HashingEmbedder) Name() string {
+	return fmt.Sprintf("hash-%d", hashDimensions)
+}
+
+func (HashingEmbedder) Remote() bool {
+	return false
+}
+
+// Shared wording scores low, so a moderate threshold already catches
+// the same bug filed twice.
+func (HashingEmbedder) DuplicateThreshold() float64 {
+	return 0.55
+}
+
+func (HashingEmbedder) Embed(ctx context.Context, text string) ([]float32, Usage, error) {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		// Words like "in" or "on" say little about what a task is about
+		if len(w) >= 3 || unicode.IsDigit(rune(w[0])) {
+			words = append(words, w)
+		}
+	}
+
+	vec := make([]float32, hashDimensions)
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// The top bit picks the sign so unrelated features cancel out
+		// rather than pile up in shared buckets
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%hashDimensions] += weight
+	}
+	for i, w := range words {
+		add(w, 1)
+		if i > 0 {
+			add(words[i-1]+" "+w, 0.5)
+		}
+	}
+
+	normalize(vec)
+	return vec, Usage{}, nil
+}
+
+// DefaultEmbeddingModel is the Gemini model used by GeminiEmbedder.
+const DefaultEmbeddingModel = "text-embedding-004"
+
+// GeminiEmbedder embeds text with the Gemini embedding API.
+type GeminiEmbedder struct {
+	model *genai.EmbeddingModel
+	name  string
+}
+
+func NewGeminiEmbedder() (*GeminiEmbedder, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set: %w", ErrNotConfigured)
+	}
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
+	}
+	return &GeminiEmbedder{
+		model: client.EmbeddingModel(DefaultEmbeddingModel),
+		name:  "gemini-" + DefaultEmbeddingModel,
+	}, nil
+}
+
+func (g *GeminiEmbedder) Name() string {
+	return g.name
+}
+
+func (g *GeminiEmbedder) Remote() bool {
+	return true
+}
+
+// Model embeddings score merely related text well above unrelated text,
+// so only close paraphrases should count as duplicates.
+func (g *GeminiEmbedder) DuplicateThreshold() float64 {
+	return 0.8
+}
+
+// Embed reports the prompt tokens as estimated from the text's length,
+// since the embedding API doesn't return a count.
+func (g *GeminiEmbedder) Embed(ctx context.Context, text string) ([]float32, Usage, error) {
+	usage := Usage{Model: DefaultEmbeddingModel, PromptTokens: (len(text) + 3) / 4}
+
+	var resp *genai.EmbedContentResponse
+	start := time.Now()
+	err := callProvider(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = g.model.EmbedContent(ctx, genai.Text(text))
+		return err
+	})
+	usage.Latency = time.Since(start)
+	if err != nil {
+		return nil, usage, err
+	}
+	if resp.Embedding == nil || len(resp.Embedding.Values) == 0 {
+		return nil, usage, ErrNoResponse
+	}
+	vec := append([]float32(nil), resp.Embedding.Values...)
+	normalize(vec)
+	return vec, usage, nil
+}
+
+var embedder Embedder = HashingEmbedder{}
+
+// InitEmbedder picks the embedding provider from EMBEDDING_PROVIDER:
+// "hash" (default) for the local HashingEmbedder or "gemini".
+func InitEmbedder() {
+	switch provider := os.Getenv("EMBEDDING_PROVIDER"); provider {
+	case "", "hash":
+		embedder = HashingEmbedder{}
+	case "gemini":
+		gemini, err := NewGeminiEmbedder()
+		if err != nil {
+			fmt.Printf("gemini embeddings unavailable, using local hashing: %v\n", err)
+			embedder = HashingEmbedder{}
+			return
+		}
+		embedder = gemini
+	default:
+		fmt.Printf("unknown EMBEDDING_PROVIDER %q, using local hashing\n", provider)
+		embedder = HashingEmbedder{}
+	}
+}
+
+func GetEmbedder() Embedder {
+	return embedder
+}
+
+// ErrDimensionMismatch means two vectors came from different embedders.
+var ErrDimensionMismatch = errors.New("embedding dimensions differ")
+
+// CosineSimilarity of two vectors, between -1 and 1.
+func CosineSimilarity(a, b []float32) (float64, error) {
+	if len(a) != len(b) {
+		return 0, ErrDimensionMismatch
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0, nil
+	}
+	return dot / math.Sqrt(normA*normB), nil
+}
+
+func normalize(vec []float32) {
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+}
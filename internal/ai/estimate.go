@@ -0,0 +1,176 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// EstimateExample is a completed task the team estimated or timed, shown to
+// the model as a reference.
+type EstimateExample struct {
+	Title         string
+	Points        float64
+	EstimateHours float64
+	ActualHours   float64
+}
+
+// EstimateHistory is what the team's completed work says about its pace:
+// similar tasks as examples, the median working hours per story point and
+// the median of actual over estimated hours. Zero values are left out.
+type EstimateHistory struct {
+	Examples      []EstimateExample
+	HoursPerPoint float64
+	OverrunRatio  float64
+}
+
+// EffortEstimate holds the estimate for a task and one per subtask, in the
+// order the subtasks were given.
+type EffortEstimate struct {
+	Task     types.EffortEstimate   `json:"task"`
+	SubTasks []types.EffortEstimate `json:"sub_tasks"`
+}
+
+var estimateSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"points":     {Type: genai.TypeNumber},
+		"hours":      {Type: genai.TypeNumber},
+		"confidence": {Type: genai.TypeNumber},
+		"rationale":  {Type: genai.TypeString},
+	},
+	Required: []string{"points", "hours", "confidence", "rationale"},
+}
+
+var effortEstimateSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"task":      estimateSchema,
+		"sub_tasks": {Type: genai.TypeArray, Items: estimateSchema},
+	},
+	Required: []string{"task", "sub_tasks"},
+}
+
+// Bounds on what an estimate may say
+const (
+	MaxEstimatePoints = 100
+	MaxEstimateHours  = 1000
+)
+
+// EstimateEffort asks the model for story points and hours for task and
+// each of subTasks, calibrated against history, with the organization's
+// estimate prompt. Like AnalyzeTask, text is redacted before it is sent
+// and an unusable answer is sent back once for repair.
+func (g *GeminiClient) EstimateEffort(ctx context.Context, task types.Task, subTasks []types.Task, history EstimateHistory, tmpl *Prompt, redactor *Redactor) (*EffortEstimate, Usage, error) {
+	usage := Usage{Model: g.modelName}
+	prompt, err := RenderEstimatePrompt(tmpl, task, subTasks, history)
+	if err != nil {
+		return nil, usage, err
+	}
+
+	prompt, redaction := redactor.Redact(prompt)
+	usage.Redactions = redaction.Total()
+	if usage.Redactions > 0 {
+		fmt.Printf("redacted %d values (%s) from estimate of task %d in org %d\n", usage.Redactions, redaction, task.TaskID, task.OrgID)
+	}
+
+	var estimate *EffortEstimate
+	err = g.generateJSON(ctx, g.jsonModel(effortEstimateSchema), &usage, prompt, func(answer string) (err error) {
+		estimate, err = parseEffortEstimate(answer, len(subTasks))
+		return err
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+
+	estimate.Task.Rationale = redaction.Restore(estimate.Task.Rationale)
+	for i := range estimate.SubTasks {
+		estimate.SubTasks[i].Rationale = redaction.Restore(estimate.SubTasks[i].Rationale)
+	}
+	return estimate, usage, nil
+}
+
+// RenderEstimatePrompt renders the estimate prompt for task, its subTasks
+// and the team's history.
+func RenderEstimatePrompt(prompt *Prompt, task types.Task, subTasks []types.Task, history EstimateHistory) (string, error) {
+	var subTaskList strings.Builder
+	for i, s := range subTasks {
+		fmt.Fprintf(&subTaskList, "\n%d. %s", i+1, s.Title)
+		if s.Complexity != "" {
+			fmt.Fprintf(&subTaskList, " (complexity: %s)", s.Complexity)
+		}
+		if s.Description != "" {
+			fmt.Fprintf(&subTaskList, ": %s", truncate(s.Description, maxContextLine))
+		}
+	}
+	if len(subTasks) == 0 {
+		subTaskList.WriteString(" none")
+	}
+
+	var exampleList strings.Builder
+	for _, e := range history.Examples {
+		fmt.Fprintf(&exampleList, "\n- %s:", truncate(e.Title, maxContextLine))
+		if e.Points > 0 {
+			fmt.Fprintf(&exampleList, " estimated %g points", e.Points)
+		}
+		if e.EstimateHours > 0 {
+			fmt.Fprintf(&exampleList, " estimated %.1f hours", e.EstimateHours)
+		}
+		fmt.Fprintf(&exampleList, " took %.1f working hours", e.ActualHours)
+	}
+	if len(history.Examples) == 0 {
+		exampleList.WriteString(" none yet")
+	}
+
+	var pace strings.Builder
+	if history.HoursPerPoint > 0 {
+		fmt.Fprintf(&pace, "\nThe team has been taking about %.1f working hours per story point.", history.HoursPerPoint)
+	}
+	if history.OverrunRatio > 0 {
+		fmt.Fprintf(&pace, "\nIts tasks have been taking %.2f times as long as estimated.", history.OverrunRatio)
+	}
+
+	return prompt.Render(PromptData{
+		Task:     task,
+		SubTasks: subTaskList.String(),
+		History:  exampleList.String() + pace.String(),
+	})
+}
+
+func parseEffortEstimate(text string, subTasks int) (*EffortEstimate, error) {
+	var estimate EffortEstimate
+	if err := decodeJSONAnswer(text, &estimate); err != nil {
+		return nil, err
+	}
+
+	problems := validateEstimate("task", &estimate.Task)
+	if len(estimate.SubTasks) != subTasks {
+		problems = append(problems, fmt.Sprintf("expected %d sub_tasks estimates, got %d", subTasks, len(estimate.SubTasks)))
+	}
+	for i := range estimate.SubTasks {
+		problems = append(problems, validateEstimate(fmt.Sprintf("sub_tasks[%d]", i), &estimate.SubTasks[i])...)
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return &estimate, nil
+}
+
+func validateEstimate(name string, e *types.EffortEstimate) []string {
+	var problems []string
+	if e.Points <= 0 || e.Points > MaxEstimatePoints {
+		problems = append(problems, fmt.Sprintf("%s.points %g is not between 0 and %d", name, e.Points, MaxEstimatePoints))
+	}
+	if e.Hours <= 0 || e.Hours > MaxEstimateHours {
+		problems = append(problems, fmt.Sprintf("%s.hours %g is not between 0 and %d", name, e.Hours, MaxEstimateHours))
+	}
+	if e.Confidence < 0 || e.Confidence > 1 {
+		problems = append(problems, fmt.Sprintf("%s.confidence %g is not between 0 and 1", name, e.Confidence))
+	}
+	e.Rationale = strings.TrimSpace(e.Rationale)
+	return problems
+}
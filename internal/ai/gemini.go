@@ -2,24 +2,31 @@ package ai
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/adarsh-jaiss/zocket/types"
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// DefaultModel is the Gemini model used for task analysis
+const DefaultModel = "gemini-2.0-flash"
+
 type GeminiClient struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
+	client    *genai.Client
+	model     *genai.GenerativeModel
+	modelName string
 }
 
 func NewGeminiClient() (*GeminiClient, error) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
-		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set")
+		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set: %w", ErrNotConfigured)
 	}
 
 	ctx := context.Background()
@@ -28,80 +35,187 @@ func NewGeminiClient() (*GeminiClient, error) {
 		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
 	}
 
-	model := client.GenerativeModel("gemini-2.0-flash")
-	return &GeminiClient{
-		client: client,
-		model:  model,
-	}, nil
+	g := &GeminiClient{
+		client:    client,
+		modelName: DefaultModel,
+	}
+	g.model = g.jsonModel(breakdownSchema)
+	return g, nil
 }
 
-func (g *GeminiClient) AnalyzeTask(task types.Task) (*types.AITaskBreakdownResponse, error) {
-	prompt := fmt.Sprintf(`Analyze the following task and break it down into smaller, manageable subtasks:
-
-Task Title: %s
-Description: %s
-Priority: %s
-
-Please provide:
-1. A detailed analysis of the task
-2. A list of suggested subtasks with descriptions
-3. Estimated complexity for each subtask (High/Medium/Low)
-4. Recommended order of completion
-5. Any potential dependencies between subtasks
-
-Format the response as a JSON object with the following structure:
-{
-    "analysis": "overall analysis text",
-    "suggestions": [
-        {
-            "suggestion_text": "detailed breakdown and recommendation",
-            "sub_tasks": [
-                {
-                    "title": "subtask title",
-                    "description": "subtask description",
-                    "priority": "High/Medium/Low"
-                }
-            ]
-        }
-    ]
-}`, task.Title, task.Description, task.Priority)
+// jsonModel returns a model that answers with JSON matching schema.
+func (g *GeminiClient) jsonModel(schema *genai.Schema) *genai.GenerativeModel {
+	model := g.client.GenerativeModel(g.modelName)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = schema
+	return model
+}
 
-	ctx := context.Background()
-	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
+// AnalyzeTask asks the model to break task down into subtasks, using prompt
+// (an "analyze" template, see GetPrompt) to word the request. Values found
+// by redactor are replaced with placeholders before the prompt is sent and
+// put back in the breakdown; a nil redactor sends the prompt as is. The returned
+// Usage is filled in whenever the model was reached, even if its answer
+// could not be parsed, since those tokens are billed too.
+//
+// An answer that isn't valid JSON or fails validation is sent back to the
+// model once for repair. Errors are a *ProviderError when the model could
+// not be reached, or ErrNoResponse, a *ParseError or a *ValidationError
+// when its answer was unusable.
+func (g *GeminiClient) AnalyzeTask(ctx context.Context, task types.Task, tc TaskContext, prompt *Prompt, redactor *Redactor) (*types.AITaskBreakdownResponse, Usage, error) {
+	return g.analyze(ctx, task, tc, prompt, redactor, nil)
+}
+
+// AnalyzeTaskStream works like AnalyzeTask but streams the answer, calling
+// onChunk with each piece of text as the model produces it. The breakdown
+// is only returned once the whole answer has arrived and parsed. A repair,
+// if needed, is not streamed. Chunks still contain the placeholders of
+// redacted values.
+func (g *GeminiClient) AnalyzeTaskStream(ctx context.Context, task types.Task, tc TaskContext, prompt *Prompt, redactor *Redactor, onChunk func(text string)) (*types.AITaskBreakdownResponse, Usage, error) {
+	return g.analyze(ctx, task, tc, prompt, redactor, onChunk)
+}
+
+func (g *GeminiClient) analyze(ctx context.Context, task types.Task, tc TaskContext, tmpl *Prompt, redactor *Redactor, onChunk func(text string)) (*types.AITaskBreakdownResponse, Usage, error) {
+	usage := Usage{Model: g.modelName}
+	prompt, err := RenderAnalyzePrompt(tmpl, task, tc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate content: %v", err)
+		return nil, usage, err
 	}
 
-	fmt.Printf("%+v\n", resp.Candidates[0].Content)
+	prompt, redaction := redactor.Redact(prompt)
+	usage.Redactions = redaction.Total()
+	if usage.Redactions > 0 {
+		fmt.Printf("redacted %d values (%s) from analysis of task %d in org %d\n", usage.Redactions, redaction, task.TaskID, task.OrgID)
+	}
 
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return nil, fmt.Errorf("no response from Gemini API")
+	var aiResp *types.AITaskBreakdownResponse
+	parse := func(answer string) (err error) {
+		aiResp, err = parseBreakdown(answer)
+		return err
+	}
+	if onChunk != nil {
+		var text string
+		text, err = g.generateStream(ctx, g.model, &usage, onChunk, genai.Text(prompt))
+		if err == nil {
+			err = g.repairJSON(ctx, g.model, &usage, prompt, text, parse)
+		}
+	} else {
+		err = g.generateJSON(ctx, g.model, &usage, prompt, parse)
+	}
+	if err != nil {
+		return nil, usage, err
 	}
 
-	// Parse the response
-	var aiResp types.AITaskBreakdownResponse
+	redaction.restoreBreakdown(aiResp)
+	aiResp.TaskID = task.TaskID
+	return aiResp, usage, nil
+}
 
-	// Debug logging
-	fmt.Printf("Response type: %T\n", resp.Candidates[0].Content.Parts[0])
-	fmt.Printf("Response value: %#v\n", resp.Candidates[0].Content.Parts[0])
+// generateJSON asks model for an answer to prompt and hands it to parse,
+// which decodes and validates it. See repairJSON for unusable answers.
+func (g *GeminiClient) generateJSON(ctx context.Context, model *genai.GenerativeModel, usage *Usage, prompt string, parse func(answer string) error) error {
+	answer, err := g.generate(ctx, model, usage, genai.Text(prompt))
+	if err != nil {
+		return err
+	}
+	return g.repairJSON(ctx, model, usage, prompt, answer, parse)
+}
 
-	// Get the response text
-	text := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
+// repairJSON hands answer to parse. If it isn't valid JSON or fails
+// validation, it is sent back to the model once for repair and the repaired
+// answer must parse.
+func (g *GeminiClient) repairJSON(ctx context.Context, model *genai.GenerativeModel, usage *Usage, prompt, answer string, parse func(answer string) error) error {
+	err := parse(answer)
+	var parseErr *ParseError
+	var validationErr *ValidationError
+	if !errors.As(err, &parseErr) && !errors.As(err, &validationErr) {
+		return err
+	}
 
-	// Clean up the response - remove markdown code blocks
-	if len(text) > 8 && text[:8] == "```json\n" {
-		text = text[8:]
+	fmt.Printf("asking the model to repair its answer: %v\n", err)
+	answer, err = g.generate(ctx, model, usage, genai.Text(prompt), genai.Text(repairPrompt(answer, err)))
+	if err != nil {
+		return err
 	}
-	if len(text) > 4 && text[len(text)-4:] == "\n```" {
-		text = text[:len(text)-4]
+	return parse(answer)
+}
+
+// generate makes one model call, retried as callProvider allows, and adds
+// its tokens and latency to usage.
+func (g *GeminiClient) generate(ctx context.Context, model *genai.GenerativeModel, usage *Usage, parts ...genai.Part) (string, error) {
+	var text string
+	err := callProvider(ctx, func(ctx context.Context) error {
+		start := time.Now()
+		resp, err := model.GenerateContent(ctx, parts...)
+		usage.Latency += time.Since(start)
+		if err != nil {
+			return err
+		}
+		addUsage(usage, resp)
+		text = responseText(resp)
+		return nil
+	})
+	return text, err
+}
+
+// generateStream is generate with the answer streamed to onChunk. Once a
+// chunk has been passed on, a failed stream is not retried.
+func (g *GeminiClient) generateStream(ctx context.Context, model *genai.GenerativeModel, usage *Usage, onChunk func(text string), parts ...genai.Part) (string, error) {
+	var text strings.Builder
+	err := callProvider(ctx, func(ctx context.Context) error {
+		start := time.Now()
+		iter := model.GenerateContentStream(ctx, parts...)
+		defer func() {
+			usage.Latency += time.Since(start)
+			addUsage(usage, iter.MergedResponse())
+		}()
+
+		text.Reset()
+		for {
+			resp, err := iter.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				if text.Len() > 0 {
+					return &noRetry{err: err}
+				}
+				return err
+			}
+
+			chunk := responseText(resp)
+			if chunk == "" {
+				continue
+			}
+			text.WriteString(chunk)
+			onChunk(chunk)
+		}
+	})
+	if err != nil {
+		return "", err
 	}
+	return text.String(), nil
+}
 
-	if err := json.Unmarshal([]byte(text), &aiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse Gemini response: %v", err)
+// responseText joins the text parts of the first candidate.
+func responseText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
 	}
+	return text.String()
+}
 
-	aiResp.TaskID = task.TaskID
-	return &aiResp, nil
+func addUsage(usage *Usage, resp *genai.GenerateContentResponse) {
+	if resp != nil && resp.UsageMetadata != nil {
+		usage.PromptTokens += int(resp.UsageMetadata.PromptTokenCount)
+		usage.CompletionTokens += int(resp.UsageMetadata.CandidatesTokenCount)
+	}
 }
 
 func (g *GeminiClient) Close() {
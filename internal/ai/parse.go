@@ -0,0 +1,107 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// TaskDraft is the model's reading of a free-text task. Assignee is the
+// person as written, e.g. "Priya", still to be matched against members.
+type TaskDraft struct {
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Priority    types.TaskPriority `json:"priority"`
+	Assignee    string             `json:"assignee"`
+	DueDate     string             `json:"due_date"`
+}
+
+var taskDraftSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"title":       {Type: genai.TypeString},
+		"description": {Type: genai.TypeString},
+		"priority": {
+			Type: genai.TypeString,
+			Enum: []string{string(types.High), string(types.Medium), string(types.Low)},
+		},
+		"assignee": {Type: genai.TypeString},
+		"due_date": {Type: genai.TypeString},
+	},
+	Required: []string{"title", "description", "priority", "assignee", "due_date"},
+}
+
+// ParseTask turns free text like "Ask Priya to fix the login bug by
+// Friday, high priority" into a draft task. Relative dates are resolved
+// against now. Like AnalyzeTask, sensitive values are redacted and put back
+// in the draft, an unusable answer is sent back once for repair and errors
+// are typed the same way.
+func (g *GeminiClient) ParseTask(ctx context.Context, text string, now time.Time, redactor *Redactor) (*TaskDraft, Usage, error) {
+	prompt := fmt.Sprintf(`Turn the following request into a task for a task manager.
+
+Today is %s (%s).
+
+Request:
+%s
+
+Reply with a JSON object with these fields:
+- "title": a short imperative title, without the assignee, deadline or priority
+- "description": any further detail from the request, or "" if there is none
+- "priority": "High", "Medium" or "Low"; use "Medium" unless the request says otherwise
+- "assignee": the name or email of the person who should do the task exactly as written, or "" if no one is named
+- "due_date": the deadline as YYYY-MM-DD, resolving relative dates like "Friday" or "next week" to the next matching date, or "" if there is none`,
+		now.Format(types.DueDateLayout), now.Weekday(), text)
+
+	prompt, redaction := redactor.Redact(prompt)
+	usage := Usage{Model: g.modelName, Redactions: redaction.Total()}
+	if usage.Redactions > 0 {
+		fmt.Printf("redacted %d values (%s) from task text\n", usage.Redactions, redaction)
+	}
+
+	var draft *TaskDraft
+	err := g.generateJSON(ctx, g.jsonModel(taskDraftSchema), &usage, prompt, func(answer string) (err error) {
+		draft, err = parseTaskDraft(answer)
+		return err
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+
+	draft.Title = redaction.Restore(draft.Title)
+	draft.Description = redaction.Restore(draft.Description)
+	draft.Assignee = redaction.Restore(draft.Assignee)
+	return draft, usage, nil
+}
+
+func parseTaskDraft(text string) (*TaskDraft, error) {
+	var draft TaskDraft
+	if err := decodeJSONAnswer(text, &draft); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	draft.Title = strings.TrimSpace(draft.Title)
+	if draft.Title == "" {
+		problems = append(problems, "title is empty")
+	}
+	draft.Priority = normalizePriority(draft.Priority)
+	if draft.Priority != "" && !draft.Priority.IsValid() {
+		problems = append(problems, fmt.Sprintf("priority %q is not High, Medium or Low", draft.Priority))
+	}
+	draft.Assignee = strings.TrimSpace(draft.Assignee)
+	draft.DueDate = strings.TrimSpace(draft.DueDate)
+	if draft.DueDate != "" {
+		if _, err := time.Parse(types.DueDateLayout, draft.DueDate); err != nil {
+			problems = append(problems, fmt.Sprintf("due_date %q is not YYYY-MM-DD", draft.DueDate))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return &draft, nil
+}
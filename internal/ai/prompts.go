@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// Prompts are text/template files under prompts/<feature>/<version>.tmpl.
+// A new version is added as a new file; existing ones are left unchanged so
+// suggestions made with them stay comparable.
+//
+//go:embed prompts
+var promptFiles embed.FS
+
+// DefaultPromptVersion is used by organizations that haven't picked one.
+const DefaultPromptVersion = "v1"
+
+// ErrUnknownPrompt means no template exists for a feature and version.
+var ErrUnknownPrompt = errors.New("unknown prompt version")
+
+// Prompt is one version of a feature's prompt template.
+type Prompt struct {
+	Feature  string
+	Version  string
+	source   string
+	template *template.Template
+}
+
+// PromptData is what prompt templates can use: the task's fields as
+// {{.Task.Title}}, {{.Task.Description}}, {{.Task.Priority}},
+// {{.Task.Key}} and {{.Task.DueDate}}, and the rendered context sections,
+// empty or starting with a blank line, as {{.Context}}. Estimate prompts
+// also get the numbered subtasks as {{.SubTasks}} and the team's completed
+// tasks and pace as {{.History}}, each starting with a line break or
+// saying there are none.
+type PromptData struct {
+	Task     types.Task
+	Context  string
+	SubTasks string
+	History  string
+}
+
+var prompts = loadPrompts()
+
+func loadPrompts() map[string]map[string]*Prompt {
+	loaded := map[string]map[string]*Prompt{}
+	err := fs.WalkDir(promptFiles, "prompts", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".tmpl" {
+			return err
+		}
+		body, err := promptFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		feature := path.Base(path.Dir(name))
+		version := strings.TrimSuffix(path.Base(name), ".tmpl")
+		source := strings.TrimRight(string(body), "\n")
+		tmpl, err := template.New(feature + "/" + version).Option("missingkey=error").Parse(source)
+		if err != nil {
+			return err
+		}
+
+		// Catch fields that don't exist now rather than on the first call
+		prompt := &Prompt{Feature: feature, Version: version, source: source, template: tmpl}
+		if _, err := prompt.Render(PromptData{}); err != nil {
+			return err
+		}
+
+		if loaded[feature] == nil {
+			loaded[feature] = map[string]*Prompt{}
+		}
+		loaded[feature][version] = prompt
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to load prompt templates: %v", err))
+	}
+	return loaded
+}
+
+// GetPrompt returns a version of a feature's prompt.
+func GetPrompt(feature, version string) (*Prompt, error) {
+	prompt, ok := prompts[feature][version]
+	if !ok {
+		return nil, ErrUnknownPrompt
+	}
+	return prompt, nil
+}
+
+// PromptVersions lists the versions of a feature's prompt in order.
+func PromptVersions(feature string) []string {
+	var versions []string
+	for version := range prompts[feature] {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		// v2 before v10
+		if len(versions[i]) != len(versions[j]) {
+			return len(versions[i]) < len(versions[j])
+		}
+		return versions[i] < versions[j]
+	})
+	return versions
+}
+
+// PromptFeatures lists the features that have prompt templates.
+func PromptFeatures() []string {
+	var features []string
+	for feature := range prompts {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// Render fills in the template.
+func (p *Prompt) Render(data PromptData) (string, error) {
+	var out bytes.Buffer
+	if err := p.template.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s/%s: %w", p.Feature, p.Version, err)
+	}
+	return out.String(), nil
+}
+
+// Source is the unrendered template.
+func (p *Prompt) Source() string {
+	return p.source
+}
+
+// Name identifies the prompt, e.g. "analyze/v1".
+func (p *Prompt) Name() string {
+	return p.Feature + "/" + p.Version
+}
+
+// RenderAnalyzePrompt renders the analyze prompt for task with its context
+// kept within ContextTokenBudget.
+func RenderAnalyzePrompt(prompt *Prompt, task types.Task, tc TaskContext) (string, error) {
+	return prompt.Render(PromptData{
+		Task:    task,
+		Context: renderContext(tc, ContextTokenBudget()),
+	})
+}
@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListPrompts lists the prompt versions the active organization uses for
+// each AI feature that has templates. Admin only.
+func ListPrompts(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view AI prompts",
+			})
+		}
+
+		prompts := []types.AIPromptVersion{}
+		for _, feature := range PromptFeatures() {
+			prompt, err := promptVersion(db, principal.OrgID, feature)
+			if err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to fetch AI prompts",
+				})
+			}
+			prompts = append(prompts, prompt)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"prompts": prompts,
+		})
+	}
+}
+
+// SetPromptVersion picks the prompt version the active organization uses
+// for a feature. Admin only.
+func SetPromptVersion(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		feature := c.Params("feature")
+		var req struct {
+			Version string `json:"version"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Version == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to change AI prompts",
+			})
+		}
+
+		if len(PromptVersions(feature)) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unknown AI feature",
+			})
+		}
+		if _, err := GetPrompt(feature, req.Version); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":    "Unknown prompt version",
+				"versions": PromptVersions(feature),
+			})
+		}
+
+		if err := SetPromptVersionInStore(db, principal.OrgID, feature, req.Version); err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update AI prompt",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(types.AIPromptVersion{
+			Feature:        feature,
+			Version:        req.Version,
+			DefaultVersion: DefaultPromptVersion,
+			Versions:       PromptVersions(feature),
+		})
+	}
+}
+
+func promptVersion(db *sql.DB, orgID int, feature string) (types.AIPromptVersion, error) {
+	prompt, err := GetOrgPrompt(db, orgID, feature)
+	if err != nil {
+		return types.AIPromptVersion{}, err
+	}
+	return types.AIPromptVersion{
+		Feature:        feature,
+		Version:        prompt.Version,
+		DefaultVersion: DefaultPromptVersion,
+		Versions:       PromptVersions(feature),
+	}, nil
+}
@@ -0,0 +1,44 @@
+package ai
+
+import (
+	"database/sql"
+)
+
+// GetPromptVersionFromStore returns the prompt version the organization uses
+// for feature, or DefaultPromptVersion when it hasn't picked one.
+func GetPromptVersionFromStore(db *sql.DB, orgID int, feature string) (string, error) {
+	version := DefaultPromptVersion
+	query := `SELECT version FROM ai_prompt_versions WHERE org_id = $1 AND feature = $2`
+	err := db.QueryRow(query, orgID, feature).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return version, nil
+}
+
+func SetPromptVersionInStore(db *sql.DB, orgID int, feature, version string) error {
+	query := `
+		INSERT INTO ai_prompt_versions (org_id, feature, version, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (org_id, feature) DO UPDATE SET
+			version = EXCLUDED.version,
+			updated_at = NOW()
+	`
+	_, err := db.Exec(query, orgID, feature, version)
+	return err
+}
+
+// GetOrgPrompt returns the organization's selected prompt for feature. A
+// selection whose template has since been removed falls back to the
+// default rather than failing every call.
+func GetOrgPrompt(db *sql.DB, orgID int, feature string) (*Prompt, error) {
+	version, err := GetPromptVersionFromStore(db, orgID, feature)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := GetPrompt(feature, version)
+	if err == ErrUnknownPrompt {
+		return GetPrompt(feature, DefaultPromptVersion)
+	}
+	return prompt, err
+}
@@ -0,0 +1,315 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+)
+
+// Defaults for calls to the model provider, overridable with
+// AI_CALL_TIMEOUT_SECONDS, AI_MAX_RETRIES, AI_BREAKER_FAILURES and
+// AI_BREAKER_COOLDOWN_SECONDS.
+const (
+	defaultCallTimeout     = 30 * time.Second
+	defaultMaxRetries      = 2
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+var (
+	// ErrNotConfigured means there is no API key for the provider.
+	ErrNotConfigured = errors.New("AI provider not configured")
+)
+
+// CircuitOpenError means recent calls to the provider failed and calls are
+// refused until RetryAt, when one is let through to probe it.
+type CircuitOpenError struct {
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "AI provider unavailable, failing fast after repeated errors"
+}
+
+// noRetry marks an error that must not be retried even though its cause
+// would be, e.g. a stream that failed after text was already passed on.
+type noRetry struct {
+	err error
+}
+
+func (e *noRetry) Error() string { return e.err.Error() }
+func (e *noRetry) Unwrap() error { return e.err }
+
+// callProvider runs call with a timeout per attempt, retrying retryable
+// errors with jittered exponential backoff while ctx allows. Outcomes feed
+// the circuit breaker shared by all calls to the provider.
+func callProvider(ctx context.Context, call func(ctx context.Context) error) error {
+	if err := providerBreaker.allow(); err != nil {
+		return err
+	}
+
+	maxRetries := envInt("AI_MAX_RETRIES", defaultMaxRetries)
+	timeout := time.Duration(envInt("AI_CALL_TIMEOUT_SECONDS", int(defaultCallTimeout/time.Second))) * time.Second
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := call(callCtx)
+		cancel()
+		if err == nil {
+			providerBreaker.record(true)
+			return nil
+		}
+		// The caller's deadline or cancellation isn't the provider's fault
+		if ctx.Err() != nil {
+			providerBreaker.release()
+			return &ProviderError{Err: ctx.Err()}
+		}
+
+		// Any answer, even a rejection, shows the provider is up
+		providerBreaker.record(!isProviderDown(err))
+		if !IsRetryable(err) || attempt >= maxRetries {
+			return &ProviderError{Err: err}
+		}
+
+		delay := retryDelay(attempt)
+		fmt.Printf("retrying model call in %v after attempt %d failed: %v\n", delay, attempt+1, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return &ProviderError{Err: ctx.Err()}
+		}
+		if err := providerBreaker.allow(); err != nil {
+			return err
+		}
+	}
+}
+
+// retryDelay is exponential backoff with full jitter.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// IsRetryable reports whether trying the call again may succeed: timeouts,
+// network errors, rate limits and server errors.
+func IsRetryable(err error) bool {
+	var nr *noRetry
+	if errors.As(err, &nr) {
+		return false
+	}
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if code := providerStatus(err); code != 0 {
+		return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isProviderDown reports whether err counts against the circuit breaker.
+// Rate limits and rejected requests show the provider is up.
+func isProviderDown(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if code := providerStatus(err); code != 0 {
+		return code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// providerStatus returns the HTTP status the provider answered with, or 0.
+func providerStatus(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// ErrorStatus maps an error from an AI call to the HTTP status to answer
+// with.
+func ErrorStatus(err error) int {
+	var open *CircuitOpenError
+	var blocked *genai.BlockedError
+	var quotaErr *QuotaError
+	switch {
+	case err == nil:
+		return fiber.StatusOK
+	case errors.As(err, &quotaErr):
+		return fiber.StatusTooManyRequests
+	case errors.Is(err, ErrNotConfigured), errors.As(err, &open):
+		return fiber.StatusServiceUnavailable
+	case errors.As(err, &blocked), IsInvalidOutput(err):
+		return fiber.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		return fiber.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return fiber.StatusRequestTimeout
+	}
+
+	switch code := providerStatus(err); {
+	case code == http.StatusTooManyRequests:
+		return fiber.StatusTooManyRequests
+	case code == http.StatusServiceUnavailable:
+		return fiber.StatusServiceUnavailable
+	case code == http.StatusGatewayTimeout:
+		return fiber.StatusGatewayTimeout
+	case code != 0:
+		return fiber.StatusBadGateway
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fiber.StatusBadGateway
+	}
+	return fiber.StatusInternalServerError
+}
+
+// WriteError answers a failed AI call with the status from ErrorStatus and
+// a message for it, using fallback for internal errors. A used up quota is
+// answered like CheckQuota does.
+func WriteError(c *fiber.Ctx, err error, fallback string) error {
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return writeQuotaError(c, quotaErr)
+	}
+
+	status := ErrorStatus(err)
+	message := fallback
+	switch status {
+	case fiber.StatusServiceUnavailable:
+		message = "AI service is temporarily unavailable"
+		var open *CircuitOpenError
+		if errors.As(err, &open) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(time.Until(open.RetryAt).Seconds())+1))
+		}
+		if errors.Is(err, ErrNotConfigured) {
+			message = "AI service is not configured"
+		}
+	case fiber.StatusUnprocessableEntity:
+		message = "The AI model could not produce a usable answer"
+	case fiber.StatusGatewayTimeout:
+		message = "AI service timed out"
+	case fiber.StatusRequestTimeout:
+		message = "Request was cancelled"
+	case fiber.StatusTooManyRequests:
+		message = "AI service is rate limited, try again shortly"
+	case fiber.StatusBadGateway:
+		message = "AI service returned an error"
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+// breaker opens after a run of consecutive failures and stays open for a
+// cooldown, after which a single call is let through. Its success closes
+// the breaker again; its failure reopens it. A threshold of 0 disables it.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var providerBreaker = &breaker{}
+
+func breakerThreshold() int {
+	return envInt("AI_BREAKER_FAILURES", defaultBreakerFailures)
+}
+
+// Available returns a *CircuitOpenError while the provider is considered
+// down, so work that would need it can be refused up front.
+func Available() error {
+	b := providerBreaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	threshold := breakerThreshold()
+	if threshold == 0 || b.failures < threshold {
+		return nil
+	}
+	retryAt := b.openedAt.Add(breakerCooldown())
+	if time.Now().Before(retryAt) {
+		return &CircuitOpenError{RetryAt: retryAt}
+	}
+	return nil
+}
+
+func breakerCooldown() time.Duration {
+	return time.Duration(envInt("AI_BREAKER_COOLDOWN_SECONDS", int(defaultBreakerCooldown/time.Second))) * time.Second
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	threshold := breakerThreshold()
+	if threshold == 0 || b.failures < threshold {
+		return nil
+	}
+	retryAt := b.openedAt.Add(breakerCooldown())
+	if time.Now().Before(retryAt) || b.probing {
+		return &CircuitOpenError{RetryAt: retryAt}
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if threshold := breakerThreshold(); threshold > 0 && b.failures >= threshold {
+		if b.failures == threshold {
+			fmt.Println("AI provider circuit breaker opened")
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends a call without an outcome, e.g. one the caller cancelled.
+func (b *breaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
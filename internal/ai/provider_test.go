@@ -0,0 +1,257 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+)
+
+func apiError(code int) error {
+	return &googleapi.Error{Code: code}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"rate limited", apiError(http.StatusTooManyRequests), true},
+		{"request timeout", apiError(http.StatusRequestTimeout), true},
+		{"server error", apiError(http.StatusInternalServerError), true},
+		{"unavailable", apiError(http.StatusServiceUnavailable), true},
+		{"bad request", apiError(http.StatusBadRequest), false},
+		{"forbidden", apiError(http.StatusForbidden), false},
+		{"wrapped server error", &ProviderError{Err: apiError(http.StatusBadGateway)}, true},
+		{"network error", &net.DNSError{Err: "no such host", IsTemporary: true}, true},
+		{"circuit open", &CircuitOpenError{RetryAt: time.Now()}, true},
+		{"marked not retryable", &noRetry{err: apiError(http.StatusServiceUnavailable)}, false},
+		{"other error", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsProviderDown(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline", context.DeadlineExceeded, true},
+		{"server error", apiError(http.StatusInternalServerError), true},
+		{"network error", &net.DNSError{Err: "no such host"}, true},
+		{"rate limited", apiError(http.StatusTooManyRequests), false},
+		{"bad request", apiError(http.StatusBadRequest), false},
+		{"other error", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isProviderDown(tt.err); got != tt.want {
+				t.Errorf("isProviderDown(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, fiber.StatusOK},
+		{"not configured", fmt.Errorf("no key: %w", ErrNotConfigured), fiber.StatusServiceUnavailable},
+		{"circuit open", &CircuitOpenError{RetryAt: time.Now()}, fiber.StatusServiceUnavailable},
+		{"blocked", &genai.BlockedError{}, fiber.StatusUnprocessableEntity},
+		{"no response", ErrNoResponse, fiber.StatusUnprocessableEntity},
+		{"unparsable", &ParseError{Err: errors.New("bad json")}, fiber.StatusUnprocessableEntity},
+		{"invalid", &ValidationError{Problems: []string{"title is empty"}}, fiber.StatusUnprocessableEntity},
+		{"deadline", &ProviderError{Err: context.DeadlineExceeded}, fiber.StatusGatewayTimeout},
+		{"cancelled", &ProviderError{Err: context.Canceled}, fiber.StatusRequestTimeout},
+		{"quota", &QuotaError{Scope: QuotaScopeUser}, fiber.StatusTooManyRequests},
+		{"rate limited", &ProviderError{Err: apiError(http.StatusTooManyRequests)}, fiber.StatusTooManyRequests},
+		{"unavailable", &ProviderError{Err: apiError(http.StatusServiceUnavailable)}, fiber.StatusServiceUnavailable},
+		{"provider timeout", &ProviderError{Err: apiError(http.StatusGatewayTimeout)}, fiber.StatusGatewayTimeout},
+		{"rejected", &ProviderError{Err: apiError(http.StatusBadRequest)}, fiber.StatusBadGateway},
+		{"server error", &ProviderError{Err: apiError(http.StatusInternalServerError)}, fiber.StatusBadGateway},
+		{"network error", &ProviderError{Err: &net.DNSError{Err: "no such host"}}, fiber.StatusBadGateway},
+		{"other error", errors.New("boom"), fiber.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorStatus(tt.err); got != tt.want {
+				t.Errorf("ErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 500 * time.Millisecond},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, retryMaxDelay},
+		{20, retryMaxDelay},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := retryDelay(tt.attempt); got <= 0 || got > tt.max {
+				t.Fatalf("retryDelay(%d) = %v, want within (0, %v]", tt.attempt, got, tt.max)
+			}
+		}
+	}
+}
+
+// breakerStep is one thing that happens to a breaker: a call asking to go
+// ahead, the outcome of a call, or the cooldown running out.
+type breakerStep struct {
+	op       string // "allow", "success", "failure", "release" or "cooldown"
+	wantOpen bool   // for "allow", whether the call is refused
+}
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures string
+		steps    []breakerStep
+	}{
+		{
+			name:     "closed below threshold",
+			failures: "3",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"},
+				{op: "allow", wantOpen: false},
+				{op: "success"}, {op: "failure"}, {op: "failure"},
+				{op: "allow", wantOpen: false},
+			},
+		},
+		{
+			name:     "opens at threshold",
+			failures: "3",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"}, {op: "failure"},
+				{op: "allow", wantOpen: true},
+				{op: "allow", wantOpen: true},
+			},
+		},
+		{
+			name:     "half-open lets one probe through",
+			failures: "2",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"},
+				{op: "cooldown"},
+				{op: "allow", wantOpen: false},
+				{op: "allow", wantOpen: true},
+			},
+		},
+		{
+			name:     "successful probe closes",
+			failures: "2",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"},
+				{op: "cooldown"},
+				{op: "allow", wantOpen: false},
+				{op: "success"},
+				{op: "allow", wantOpen: false},
+				{op: "allow", wantOpen: false},
+			},
+		},
+		{
+			name:     "failed probe reopens",
+			failures: "2",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"},
+				{op: "cooldown"},
+				{op: "allow", wantOpen: false},
+				{op: "failure"},
+				{op: "allow", wantOpen: true},
+				{op: "cooldown"},
+				{op: "allow", wantOpen: false},
+			},
+		},
+		{
+			name:     "released probe lets the next call probe",
+			failures: "2",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"},
+				{op: "cooldown"},
+				{op: "allow", wantOpen: false},
+				{op: "release"},
+				{op: "allow", wantOpen: false},
+				{op: "allow", wantOpen: true},
+			},
+		},
+		{
+			name:     "disabled with 0",
+			failures: "0",
+			steps: []breakerStep{
+				{op: "failure"}, {op: "failure"}, {op: "failure"}, {op: "failure"}, {op: "failure"}, {op: "failure"},
+				{op: "allow", wantOpen: false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AI_BREAKER_FAILURES", tt.failures)
+			t.Setenv("AI_BREAKER_COOLDOWN_SECONDS", "30")
+
+			b := &breaker{}
+			for i, step := range tt.steps {
+				switch step.op {
+				case "allow":
+					err := b.allow()
+					var open *CircuitOpenError
+					if gotOpen := errors.As(err, &open); gotOpen != step.wantOpen {
+						t.Fatalf("step %d: allow() = %v, want open %v", i, err, step.wantOpen)
+					}
+				case "success":
+					b.record(true)
+				case "failure":
+					b.record(false)
+				case "release":
+					b.release()
+				case "cooldown":
+					b.openedAt = b.openedAt.Add(-31 * time.Second)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitOpenErrorRetryAt(t *testing.T) {
+	t.Setenv("AI_BREAKER_FAILURES", "1")
+	t.Setenv("AI_BREAKER_COOLDOWN_SECONDS", "30")
+
+	b := &breaker{}
+	b.record(false)
+	err := b.allow()
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("allow() = %v, want *CircuitOpenError", err)
+	}
+	if wait := time.Until(open.RetryAt); wait <= 29*time.Second || wait > 30*time.Second {
+		t.Errorf("RetryAt is %v away, want about 30s", wait)
+	}
+}
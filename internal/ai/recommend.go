@@ -0,0 +1,116 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// RecommendCandidate is a member who could take a task, with what the
+// model needs to judge fit.
+type RecommendCandidate struct {
+	UserID    int
+	Name      string
+	OpenTasks int
+	// RecentTasks are titles of tasks the member worked on, most similar
+	// to the task first
+	RecentTasks []string
+}
+
+// Recommendation is a suggested priority and assignee with the reasoning
+// behind them. AssigneeID is 0 when nobody fits.
+type Recommendation struct {
+	Priority   types.TaskPriority `json:"priority"`
+	AssigneeID int                `json:"assignee_id"`
+	Rationale  string             `json:"rationale"`
+}
+
+var recommendationSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"priority": {
+			Type: genai.TypeString,
+			Enum: []string{string(types.High), string(types.Medium), string(types.Low)},
+		},
+		"assignee_id": {Type: genai.TypeInteger},
+		"rationale":   {Type: genai.TypeString},
+	},
+	Required: []string{"priority", "assignee_id", "rationale"},
+}
+
+// RecommendTask asks the model for a priority and the best of candidates
+// to assign task to. Sensitive values are redacted and put back in the
+// rationale. The answer is validated but not repaired; callers have a
+// heuristic to fall back on.
+func (g *GeminiClient) RecommendTask(ctx context.Context, task types.Task, candidates []RecommendCandidate, redactor *Redactor) (*Recommendation, Usage, error) {
+	var members strings.Builder
+	for _, c := range candidates {
+		fmt.Fprintf(&members, "\n- id %d, %s, %d open tasks", c.UserID, c.Name, c.OpenTasks)
+		if len(c.RecentTasks) > 0 {
+			fmt.Fprintf(&members, ", recently worked on: %s", strings.Join(c.RecentTasks, "; "))
+		}
+	}
+
+	dueDate := task.DueDate
+	if dueDate == "" {
+		dueDate = "none"
+	}
+	prompt := fmt.Sprintf(`Recommend a priority and an assignee for this task.
+
+Task Title: %s
+Description: %s
+Due date: %s
+
+Team members:%s
+
+Prefer members who have worked on similar tasks, but avoid overloading anyone. Reply with a JSON object:
+- "priority": "High", "Medium" or "Low"
+- "assignee_id": the id of the best member, or 0 if there are no members
+- "rationale": one or two sentences explaining both choices`, task.Title, task.Description, dueDate, members.String())
+
+	prompt, redaction := redactor.Redact(prompt)
+	usage := Usage{Model: g.modelName, Redactions: redaction.Total()}
+	if usage.Redactions > 0 {
+		fmt.Printf("redacted %d values (%s) from recommendation for task %d in org %d\n", usage.Redactions, redaction, task.TaskID, task.OrgID)
+	}
+
+	text, err := g.generate(ctx, g.jsonModel(recommendationSchema), &usage, genai.Text(prompt))
+	if err != nil {
+		return nil, usage, err
+	}
+
+	var rec Recommendation
+	if err := decodeJSONAnswer(text, &rec); err != nil {
+		return nil, usage, err
+	}
+
+	var problems []string
+	rec.Priority = normalizePriority(rec.Priority)
+	if !rec.Priority.IsValid() {
+		problems = append(problems, fmt.Sprintf("priority %q is not High, Medium or Low", rec.Priority))
+	}
+	if rec.AssigneeID != 0 && !hasCandidate(candidates, rec.AssigneeID) {
+		problems = append(problems, fmt.Sprintf("assignee_id %d is not a listed member", rec.AssigneeID))
+	}
+	rec.Rationale = strings.TrimSpace(rec.Rationale)
+	if rec.Rationale == "" {
+		problems = append(problems, "rationale is empty")
+	}
+	if len(problems) > 0 {
+		return nil, usage, &ValidationError{Problems: problems}
+	}
+	rec.Rationale = redaction.Restore(rec.Rationale)
+	return &rec, usage, nil
+}
+
+func hasCandidate(candidates []RecommendCandidate, userID int) bool {
+	for _, c := range candidates {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
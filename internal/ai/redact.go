@@ -0,0 +1,256 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// Kinds of redacted values. Custom rules are counted under their own name.
+const (
+	RedactEmail  = "email"
+	RedactPhone  = "phone"
+	RedactCard   = "card"
+	RedactSecret = "secret"
+	RedactCustom = "custom"
+)
+
+// Limits on organization redaction rules
+const (
+	MaxRedactionRules   = 50
+	MaxRedactionPattern = 500
+)
+
+var (
+	ErrInvalidPattern = errors.New("pattern must be a valid regular expression that doesn't match empty text")
+)
+
+// detector finds one kind of sensitive value. When group is set only that
+// capture group is replaced, e.g. the value of "api_key=...". valid can
+// reject matches the pattern is too loose to rule out.
+type detector struct {
+	kind    string
+	name    string
+	pattern *regexp.Regexp
+	group   int
+	valid   func(match string) bool
+}
+
+// builtinDetectors run after an organization's own rules, secrets first so
+// that an email inside a token doesn't split it.
+var builtinDetectors = []detector{
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]{16,}=*)`), group: 1},
+	{kind: RedactSecret, pattern: regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|token|password|passwd|pwd)["']?\s*[:=]\s*["']?([^\s"',;]{8,})`), group: 1},
+	{kind: RedactEmail, pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+	{kind: RedactCard, pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+	{kind: RedactPhone, pattern: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{1,4}\)[\s.-]?)?\b\d{2,4}[\s.-]\d{3,4}(?:[\s.-]?\d{2,4})?\b`), valid: phoneDigits},
+}
+
+// Redactor replaces sensitive values in text sent to a model with
+// placeholders such as [EMAIL_1].
+type Redactor struct {
+	detectors []detector
+}
+
+// NewRedactor returns a Redactor using the built-in detectors and the given
+// organization rules.
+func NewRedactor(rules []types.RedactionRule) (*Redactor, error) {
+	r := &Redactor{}
+	for _, rule := range rules {
+		re, err := CompileRedactionPattern(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %q: %w", rule.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{kind: RedactCustom, name: rule.Name, pattern: re})
+	}
+	r.detectors = append(r.detectors, builtinDetectors...)
+	return r, nil
+}
+
+// CompileRedactionPattern checks an organization's pattern.
+func CompileRedactionPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" || len(pattern) > MaxRedactionPattern {
+		return nil, ErrInvalidPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil || re.MatchString("") {
+		return nil, ErrInvalidPattern
+	}
+	return re, nil
+}
+
+// Redaction remembers what a Redactor replaced so it can be put back.
+type Redaction struct {
+	// Counts is the number of values replaced by kind, or by rule name for
+	// custom rules.
+	Counts       map[string]int
+	placeholders map[string]string // original -> placeholder
+	originals    map[string]string // placeholder -> original
+	next         map[string]int
+	// source is the text before redaction, so placeholders that already
+	// occur in it aren't used and restored by mistake
+	source string
+}
+
+// Redact replaces the sensitive values in text. The same value always gets
+// the same placeholder, so the model can still tell them apart.
+func (r *Redactor) Redact(text string) (string, *Redaction) {
+	red := &Redaction{
+		Counts:       map[string]int{},
+		placeholders: map[string]string{},
+		originals:    map[string]string{},
+		next:         map[string]int{},
+		source:       text,
+	}
+	if r == nil {
+		return text, red
+	}
+
+	for _, d := range r.detectors {
+		text = red.apply(d, text)
+	}
+	return text, red
+}
+
+func (red *Redaction) apply(d detector, text string) string {
+	matches := d.pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if d.group > 0 {
+			start, end = m[2*d.group], m[2*d.group+1]
+		}
+		if start < 0 || start < last {
+			continue
+		}
+
+		value := text[start:end]
+		if d.valid != nil && !d.valid(value) {
+			continue
+		}
+		// Don't redact a placeholder left by an earlier detector
+		if _, ok := red.originals[value]; ok {
+			continue
+		}
+
+		out.WriteString(text[last:start])
+		out.WriteString(red.placeholder(d, value))
+		last = end
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+func (red *Redaction) placeholder(d detector, value string) string {
+	counted := d.kind
+	if d.kind == RedactCustom {
+		counted = d.name
+	}
+	red.Counts[counted]++
+
+	if p, ok := red.placeholders[value]; ok {
+		return p
+	}
+	var p string
+	for p == "" || strings.Contains(red.source, p) {
+		red.next[d.kind]++
+		p = fmt.Sprintf("[%s_%d]", strings.ToUpper(d.kind), red.next[d.kind])
+	}
+	red.placeholders[value] = p
+	red.originals[p] = value
+	return p
+}
+
+// Total is the number of values replaced.
+func (red *Redaction) Total() int {
+	total := 0
+	for _, n := range red.Counts {
+		total += n
+	}
+	return total
+}
+
+// String lists the counts, e.g. "email=2 secret=1".
+func (red *Redaction) String() string {
+	var parts []string
+	for kind, n := range red.Counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", kind, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// Restore puts the original values back in place of placeholders.
+func (red *Redaction) Restore(text string) string {
+	if red == nil || len(red.originals) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(red.originals))
+	for p, original := range red.originals {
+		pairs = append(pairs, p, original)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// restoreBreakdown restores the placeholders in every text of a breakdown.
+func (red *Redaction) restoreBreakdown(resp *types.AITaskBreakdownResponse) {
+	resp.Analysis = red.Restore(resp.Analysis)
+	for i := range resp.Suggestions {
+		s := &resp.Suggestions[i]
+		s.SuggestionText = red.Restore(s.SuggestionText)
+		for j := range s.SubTasks {
+			s.SubTasks[j].Title = red.Restore(s.SubTasks[j].Title)
+			s.SubTasks[j].Description = red.Restore(s.SubTasks[j].Description)
+		}
+	}
+}
+
+// luhn reports whether the digits of s pass the Luhn check used by card
+// numbers.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// phoneDigits keeps phone matches with a plausible number of digits.
+func phoneDigits(s string) bool {
+	n := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return n >= 7 && n <= 15
+}
@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListRedactionRules lists the active organization's redaction rules.
+// Admin only.
+func ListRedactionRules(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view redaction rules",
+			})
+		}
+
+		rules, err := ListRedactionRulesFromStore(db, principal.OrgID)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch redaction rules",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"rules": rules,
+		})
+	}
+}
+
+// CreateRedactionRule adds a pattern to redact from text sent to models.
+// Admin only.
+func CreateRedactionRule(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var rule types.RedactionRule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		rule.Name = strings.TrimSpace(rule.Name)
+		if rule.Name == "" || len(rule.Name) > 100 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Name is required and must be at most 100 characters",
+			})
+		}
+		if _, err := CompileRedactionPattern(rule.Pattern); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid pattern: %v (at most %d characters)", err, MaxRedactionPattern),
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to change redaction rules",
+			})
+		}
+
+		rule.OrgID = principal.OrgID
+		rule, err = CreateRedactionRuleInStore(db, rule)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": fmt.Sprintf("An organization can have at most %d redaction rules", MaxRedactionRules),
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create redaction rule",
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(rule)
+	}
+}
+
+// DeleteRedactionRule removes one of the organization's rules. Admin only.
+func DeleteRedactionRule(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ruleID, err := c.ParamsInt("ruleId")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid rule ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to change redaction rules",
+			})
+		}
+
+		if err := DeleteRedactionRuleInStore(db, principal.OrgID, ruleID); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Redaction rule not found",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete redaction rule",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Redaction rule deleted successfully",
+		})
+	}
+}
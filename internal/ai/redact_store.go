@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+func ListRedactionRulesFromStore(db *sql.DB, orgID int) ([]types.RedactionRule, error) {
+	query := `
+		SELECT rule_id, org_id, name, pattern, created_at
+		FROM ai_redaction_rules
+		WHERE org_id = $1
+		ORDER BY rule_id
+	`
+	rows, err := db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []types.RedactionRule{}
+	for rows.Next() {
+		var rule types.RedactionRule
+		if err := rows.Scan(&rule.RuleID, &rule.OrgID, &rule.Name, &rule.Pattern, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// CreateRedactionRuleInStore adds a rule unless the organization already has
+// MaxRedactionRules of them, in which case it returns sql.ErrNoRows.
+func CreateRedactionRuleInStore(db *sql.DB, rule types.RedactionRule) (types.RedactionRule, error) {
+	query := `
+		INSERT INTO ai_redaction_rules (org_id, name, pattern, created_at)
+		SELECT $1, $2, $3, NOW()
+		WHERE (SELECT COUNT(*) FROM ai_redaction_rules WHERE org_id = $1) < $4
+		RETURNING rule_id, created_at
+	`
+	err := db.QueryRow(query, rule.OrgID, rule.Name, rule.Pattern, MaxRedactionRules).Scan(&rule.RuleID, &rule.CreatedAt)
+	return rule, err
+}
+
+func DeleteRedactionRuleInStore(db *sql.DB, orgID, ruleID int) error {
+	result, err := db.Exec(`DELETE FROM ai_redaction_rules WHERE org_id = $1 AND rule_id = $2`, orgID, ruleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetOrgRedactor returns a Redactor with the organization's rules. A stored
+// rule that no longer compiles is skipped rather than blocking every call.
+func GetOrgRedactor(db *sql.DB, orgID int) (*Redactor, error) {
+	rules, err := ListRedactionRulesFromStore(db, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	valid := rules[:0]
+	for _, rule := range rules {
+		if _, err := CompileRedactionPattern(rule.Pattern); err != nil {
+			fmt.Printf("skipping redaction rule %d of org %d: %v\n", rule.RuleID, orgID, err)
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return NewRedactor(valid)
+}
@@ -0,0 +1,49 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// SummarizeProject writes a short status update for a project from facts,
+// a JSON description of what happened over the period. Sensitive values
+// are redacted and put back in the summary.
+func (g *GeminiClient) SummarizeProject(ctx context.Context, projectName, facts string, redactor *Redactor) (string, Usage, error) {
+	prompt := fmt.Sprintf(`Write a concise status summary of the project %q for its team's daily standup.
+
+The facts below cover the last "days" days: tasks that moved to Done, tasks that look blocked or stalled,
+overdue tasks, and members with too many open tasks. "created" and "changes" count new tasks and task changes.
+"history" lists the most recent of those changes, newest first: who changed a task's assignee, status or
+priority, from which value to which, and when.
+
+%s
+
+Use at most five short bullet points in plain text, covering what moved to Done, what's blocked, what's overdue
+and who is overloaded, skipping anything with nothing to report. Use the history to say who moved work along
+or handed it over where that matters. Refer to tasks by key when they have one.
+Don't invent anything that isn't in the facts.`, projectName, facts)
+
+	prompt, redaction := redactor.Redact(prompt)
+	usage := Usage{Model: g.modelName, Redactions: redaction.Total()}
+	if usage.Redactions > 0 {
+		fmt.Printf("redacted %d values (%s) from summary of project %q\n", usage.Redactions, redaction, projectName)
+	}
+
+	text, err := g.generate(ctx, g.client.GenerativeModel(g.modelName), &usage, genai.Text(prompt))
+	if err != nil {
+		return "", usage, err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", usage, ErrNoResponse
+	}
+	return redaction.Restore(text), usage, nil
+}
+
+// ModelName is the model the client talks to.
+func (g *GeminiClient) ModelName() string {
+	return g.modelName
+}
@@ -0,0 +1,94 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Features that call a model, recorded with their usage
+const (
+	FeatureAnalyze   = "analyze"
+	FeatureParseTask = "parse_task"
+	FeatureRecommend = "recommend"
+	FeatureSummary   = "summary"
+	FeatureEstimate  = "estimate"
+	// FeatureEmbed is only recorded for embedders that call a model
+	FeatureEmbed = "embed"
+)
+
+// Usage is the token accounting of one model call.
+type Usage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	// Redactions is the number of sensitive values replaced in the prompt
+	Redactions int
+}
+
+// price is what a model costs in USD per million tokens.
+type price struct {
+	input  float64
+	output float64
+}
+
+// prices are list prices; AI_PRICE_INPUT_PER_MTOK and
+// AI_PRICE_OUTPUT_PER_MTOK override them for every model.
+var prices = map[string]price{
+	"gemini-2.0-flash":      {input: 0.10, output: 0.40},
+	"gemini-2.0-flash-lite": {input: 0.075, output: 0.30},
+	"gemini-1.5-flash":      {input: 0.075, output: 0.30},
+	"gemini-1.5-pro":        {input: 1.25, output: 5.00},
+}
+
+// EstimateCost returns the estimated cost of u in USD.
+func EstimateCost(u Usage) float64 {
+	p := prices[u.Model]
+	if v, err := strconv.ParseFloat(os.Getenv("AI_PRICE_INPUT_PER_MTOK"), 64); err == nil {
+		p.input = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("AI_PRICE_OUTPUT_PER_MTOK"), 64); err == nil {
+		p.output = v
+	}
+	return (float64(u.PromptTokens)*p.input + float64(u.CompletionTokens)*p.output) / 1e6
+}
+
+// Default monthly token quotas, overridable with AI_MONTHLY_TOKENS_PER_USER
+// and AI_MONTHLY_TOKENS_PER_ORG and per organization through the API.
+const (
+	defaultMonthlyTokensPerUser = 500_000
+	defaultMonthlyTokensPerOrg  = 5_000_000
+)
+
+// QuotaScope tells which quota ran out
+const (
+	QuotaScopeUser = "user"
+	QuotaScopeOrg  = "org"
+)
+
+// QuotaError is returned when a user or their organization has used up its
+// monthly AI tokens.
+type QuotaError struct {
+	Scope   string
+	Used    int
+	Limit   int
+	ResetAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("monthly AI quota for %s exceeded: %d of %d tokens used", e.Scope, e.Used, e.Limit)
+}
+
+// monthStart returns the first instant of t's calendar month.
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func envTokens(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,172 @@
+package ai
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RecordUsage saves the usage of a model call made for principal. Failures
+// are logged; the call already happened and its result is still useful.
+func RecordUsage(db *sql.DB, principal middleware.Principal, taskID int, feature string, usage Usage, success bool) {
+	err := RecordUsageInStore(db, types.AIUsage{
+		OrgID:            principal.OrgID,
+		UserID:           principal.UserID,
+		TaskID:           taskID,
+		Feature:          feature,
+		Model:            usage.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		LatencyMS:        int(usage.Latency.Milliseconds()),
+		CostUSD:          EstimateCost(usage),
+		Success:          success,
+		Redactions:       usage.Redactions,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// CheckQuota reports whether the principal's monthly AI quota allows the
+// call to go ahead. When it doesn't, a 429 response has been written and
+// the error from writing it is returned.
+func CheckQuota(c *fiber.Ctx, db *sql.DB, principal middleware.Principal) (bool, error) {
+	err := CheckQuotaInStore(db, principal.OrgID, principal.UserID)
+	if err == nil {
+		return true, nil
+	}
+
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return false, writeQuotaError(c, quotaErr)
+	}
+
+	fmt.Println(err)
+	return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to check AI quota",
+	})
+}
+
+func writeQuotaError(c *fiber.Ctx, quotaErr *QuotaError) error {
+	retryAfter := int(time.Until(quotaErr.ResetAt).Seconds())
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":    "Monthly AI quota exceeded",
+		"scope":    quotaErr.Scope,
+		"used":     quotaErr.Used,
+		"limit":    quotaErr.Limit,
+		"reset_at": quotaErr.ResetAt.Format(time.RFC3339),
+	})
+}
+
+// GetUsage reports AI usage for a calendar month, ?month=YYYY-MM defaulting
+// to the current one. Members see their own usage; admins also get the
+// organization's totals broken down by user and model.
+func GetUsage(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		month := monthStart(time.Now())
+		if m := c.Query("month"); m != "" {
+			if month, err = time.Parse("2006-01", m); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "month must look like 2024-03",
+				})
+			}
+		}
+
+		quota, err := GetQuotaFromStore(db, principal.OrgID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve AI usage",
+			})
+		}
+
+		mine, err := ListUsageTotalsFromStore(db, principal.OrgID, principal.UserID, month, "")
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve AI usage",
+			})
+		}
+
+		resp := fiber.Map{
+			"month": month.Format("2006-01"),
+			"quota": quota,
+			"user":  firstOrZero(mine),
+		}
+
+		if authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			org, err := ListUsageTotalsFromStore(db, principal.OrgID, 0, month, "")
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve AI usage",
+				})
+			}
+			byUser, err := ListUsageTotalsFromStore(db, principal.OrgID, 0, month, "user")
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve AI usage",
+				})
+			}
+			byModel, err := ListUsageTotalsFromStore(db, principal.OrgID, 0, month, "model")
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve AI usage",
+				})
+			}
+			resp["org"] = firstOrZero(org)
+			resp["by_user"] = byUser
+			resp["by_model"] = byModel
+		}
+
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
+
+// SetQuota changes the active organization's monthly AI quotas. Admin only.
+func SetQuota(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var quota types.AIQuota
+		if err := c.BodyParser(&quota); err != nil || quota.MonthlyTokensPerUser < 0 || quota.MonthlyTokensPerOrg < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to change AI quotas",
+			})
+		}
+
+		if err := SetQuotaInStore(db, principal.OrgID, quota); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update AI quota",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(quota)
+	}
+}
+
+func firstOrZero(totals []types.AIUsageTotals) types.AIUsageTotals {
+	if len(totals) == 0 {
+		return types.AIUsageTotals{}
+	}
+	return totals[0]
+}
@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// RecordUsageInStore saves the accounting record of one model call.
+func RecordUsageInStore(db *sql.DB, usage types.AIUsage) error {
+	query := `
+		INSERT INTO ai_usage (
+			org_id, user_id, task_id, feature, model, prompt_tokens, completion_tokens,
+			latency_ms, cost_usd, success, redactions, created_at
+		)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+	`
+	_, err := db.Exec(
+		query,
+		usage.OrgID,
+		usage.UserID,
+		usage.TaskID,
+		usage.Feature,
+		usage.Model,
+		usage.PromptTokens,
+		usage.CompletionTokens,
+		usage.LatencyMS,
+		usage.CostUSD,
+		usage.Success,
+		usage.Redactions,
+	)
+	return err
+}
+
+// GetQuotaFromStore returns the organization's quota, falling back to the
+// configured defaults when none was set.
+func GetQuotaFromStore(db *sql.DB, orgID int) (types.AIQuota, error) {
+	quota := types.AIQuota{
+		MonthlyTokensPerUser: envTokens("AI_MONTHLY_TOKENS_PER_USER", defaultMonthlyTokensPerUser),
+		MonthlyTokensPerOrg:  envTokens("AI_MONTHLY_TOKENS_PER_ORG", defaultMonthlyTokensPerOrg),
+	}
+
+	query := `SELECT monthly_tokens_per_user, monthly_tokens_per_org FROM ai_quotas WHERE org_id = $1`
+	err := db.QueryRow(query, orgID).Scan(&quota.MonthlyTokensPerUser, &quota.MonthlyTokensPerOrg)
+	if err != nil && err != sql.ErrNoRows {
+		return types.AIQuota{}, err
+	}
+	return quota, nil
+}
+
+func SetQuotaInStore(db *sql.DB, orgID int, quota types.AIQuota) error {
+	query := `
+		INSERT INTO ai_quotas (org_id, monthly_tokens_per_user, monthly_tokens_per_org, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (org_id) DO UPDATE SET
+			monthly_tokens_per_user = EXCLUDED.monthly_tokens_per_user,
+			monthly_tokens_per_org = EXCLUDED.monthly_tokens_per_org,
+			updated_at = NOW()
+	`
+	_, err := db.Exec(query, orgID, quota.MonthlyTokensPerUser, quota.MonthlyTokensPerOrg)
+	return err
+}
+
+// CheckQuotaInStore returns a *QuotaError when the user or the organization
+// has used up this month's tokens.
+func CheckQuotaInStore(db *sql.DB, orgID, userID int) error {
+	quota, err := GetQuotaFromStore(db, orgID)
+	if err != nil {
+		return err
+	}
+	if quota.MonthlyTokensPerUser == 0 && quota.MonthlyTokensPerOrg == 0 {
+		return nil
+	}
+
+	var userTokens, orgTokens int
+	query := `
+		SELECT
+			COALESCE(SUM(prompt_tokens + completion_tokens) FILTER (WHERE user_id = $2), 0),
+			COALESCE(SUM(prompt_tokens + completion_tokens), 0)
+		FROM ai_usage
+		WHERE org_id = $1 AND created_at >= date_trunc('month', NOW())
+	`
+	if err := db.QueryRow(query, orgID, userID).Scan(&userTokens, &orgTokens); err != nil {
+		return err
+	}
+
+	resetAt := monthStart(time.Now()).AddDate(0, 1, 0)
+	if quota.MonthlyTokensPerOrg > 0 && orgTokens >= quota.MonthlyTokensPerOrg {
+		return &QuotaError{Scope: QuotaScopeOrg, Used: orgTokens, Limit: quota.MonthlyTokensPerOrg, ResetAt: resetAt}
+	}
+	if quota.MonthlyTokensPerUser > 0 && userTokens >= quota.MonthlyTokensPerUser {
+		return &QuotaError{Scope: QuotaScopeUser, Used: userTokens, Limit: quota.MonthlyTokensPerUser, ResetAt: resetAt}
+	}
+	return nil
+}
+
+// usageGroups maps a grouping to the column it groups ai_usage by
+var usageGroups = map[string]string{
+	"":      "NULL::integer, NULL::text",
+	"user":  "user_id, NULL::text",
+	"model": "NULL::integer, model",
+}
+
+// ListUsageTotalsFromStore sums an organization's usage in the month
+// starting at month, optionally only for one user (userID != 0) and grouped
+// by "user" or "model".
+func ListUsageTotalsFromStore(db *sql.DB, orgID, userID int, month time.Time, groupBy string) ([]types.AIUsageTotals, error) {
+	group := usageGroups[groupBy]
+	query := `
+		SELECT
+			COALESCE(g_user, 0), COALESCE(g_model, ''),
+			COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+			COALESCE(SUM(cost_usd), 0)::float8
+		FROM (
+			SELECT ` + group + `, prompt_tokens, completion_tokens, cost_usd
+			FROM ai_usage
+			WHERE org_id = $1
+				AND ($2 = 0 OR user_id = $2)
+				AND created_at >= $3::date
+				AND created_at < $3::date + INTERVAL '1 month'
+		) u (g_user, g_model, prompt_tokens, completion_tokens, cost_usd)
+		GROUP BY g_user, g_model
+		ORDER BY SUM(prompt_tokens + completion_tokens) DESC
+	`
+	rows, err := db.Query(query, orgID, userID, month.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []types.AIUsageTotals{}
+	for rows.Next() {
+		var t types.AIUsageTotals
+		err := rows.Scan(
+			&t.UserID,
+			&t.Model,
+			&t.Requests,
+			&t.PromptTokens,
+			&t.CompletionTokens,
+			&t.CostUSD,
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.TotalTokens = t.PromptTokens + t.CompletionTokens
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
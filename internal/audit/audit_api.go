@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Auth event types
+const (
+	EventSignIn    = "signin"
+	EventSignUp    = "signup"
+	EventSwitchOrg = "switch_org"
+
+	// EventLinkIdentity is an identity provider account being linked to an
+	// existing local account with the same email
+	EventLinkIdentity = "link_identity"
+)
+
+// Reasons recorded for failed attempts
+const (
+	ReasonUnknownEmail  = "unknown_email"
+	ReasonWrongPassword = "wrong_password"
+	ReasonDeactivated   = "deactivated"
+	ReasonLocked        = "locked"
+	ReasonOIDCFailed    = "oidc_failed"
+	ReasonUnverified    = "unverified_email"
+
+	// ReasonPasswordReset marks a link_identity event that also reset the
+	// account's password and sessions
+	ReasonPasswordReset = "password_reset"
+)
+
+const (
+	defaultEventLimit = 50
+	maxEventLimit     = 500
+	maxUserAgentLen   = 512
+)
+
+// RecordAuthEvent stores an auth event for the request in c, filling in the
+// client IP and user agent. Failures are logged and otherwise ignored so
+// that auditing never blocks a sign-in.
+func RecordAuthEvent(db *sql.DB, c *fiber.Ctx, event types.AuthEvent) {
+	event.Email = strings.ToLower(strings.TrimSpace(event.Email))
+	event.IP = c.IP()
+	event.UserAgent = c.Get(fiber.HeaderUserAgent)
+	if len(event.UserAgent) > maxUserAgentLen {
+		event.UserAgent = event.UserAgent[:maxUserAgentLen]
+	}
+	if event.Method == "" {
+		event.Method = middleware.AuthMethodPassword
+	}
+
+	if err := RecordAuthEventInStore(db, event); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// ListMySessions returns the caller's recent sign-ins and failed attempts.
+// Pass ?limit= to get more than the default 50.
+func ListMySessions(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		limit := c.QueryInt("limit", defaultEventLimit)
+		if limit < 1 || limit > maxEventLimit {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("limit must be between 1 and %d", maxEventLimit),
+			})
+		}
+
+		events, err := ListUserAuthEventsFromStore(db, principal.UserID, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve sessions",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(events)
+	}
+}
+
+// ListAuthEvents searches the auth audit trail of the active organization.
+// Admin only.
+func ListAuthEvents(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view the audit trail",
+			})
+		}
+
+		filter, err := authEventFilterFromQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		events, err := ListOrgAuthEventsFromStore(db, principal.OrgID, filter)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve auth events",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(events)
+	}
+}
+
+func authEventFilterFromQuery(c *fiber.Ctx) (types.AuthEventFilter, error) {
+	filter := types.AuthEventFilter{
+		UserID: c.QueryInt("user_id"),
+		Email:  c.Query("email"),
+		IP:     c.Query("ip"),
+		Type:   c.Query("type"),
+		Limit:  c.QueryInt("limit", defaultEventLimit),
+		Offset: c.QueryInt("offset", 0),
+	}
+
+	if filter.Limit < 1 || filter.Limit > maxEventLimit || filter.Offset < 0 {
+		return filter, fmt.Errorf("limit must be between 1 and %d and offset not negative", maxEventLimit)
+	}
+	if s := c.Query("success"); s != "" {
+		success := c.QueryBool("success")
+		filter.Success = &success
+	}
+
+	var err error
+	if s := c.Query("since"); s != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return filter, fmt.Errorf("since must be an RFC 3339 timestamp")
+		}
+	}
+	if s := c.Query("until"); s != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return filter, fmt.Errorf("until must be an RFC 3339 timestamp")
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const authEventColumns = `
+	event_id, COALESCE(user_id, 0), COALESCE(org_id, 0), email, event_type, method, success,
+	COALESCE(reason, ''), COALESCE(ip, ''), COALESCE(user_agent, ''), created_at
+`
+
+func scanAuthEvents(rows *sql.Rows) ([]types.AuthEvent, error) {
+	events := []types.AuthEvent{}
+	for rows.Next() {
+		var event types.AuthEvent
+		var createdAt time.Time
+		err := rows.Scan(
+			&event.EventID,
+			&event.UserID,
+			&event.OrgID,
+			&event.Email,
+			&event.Type,
+			&event.Method,
+			&event.Success,
+			&event.Reason,
+			&event.IP,
+			&event.UserAgent,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.CreatedAt = createdAt.Format(time.RFC3339)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// RecordAuthEventInStore appends an event to the audit trail. Successful
+// sign-ins also move the user's logged_in_at forward.
+func RecordAuthEventInStore(db *sql.DB, event types.AuthEvent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO auth_events (user_id, org_id, email, event_type, method, success, reason, ip, user_agent, created_at)
+		VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NOW())
+	`
+	_, err = tx.Exec(
+		query,
+		event.UserID,
+		event.OrgID,
+		event.Email,
+		event.Type,
+		event.Method,
+		event.Success,
+		event.Reason,
+		event.IP,
+		event.UserAgent,
+	)
+	if err != nil {
+		return err
+	}
+
+	if event.Success && event.Type == EventSignIn && event.UserID != 0 {
+		query = `UPDATE users SET logged_in_at = NOW() WHERE user_id = $1`
+		if _, err := tx.Exec(query, event.UserID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// HasPasswordSignInInStore reports whether the user has ever signed in with
+// their password.
+func HasPasswordSignInInStore(db *sql.DB, userID int) (bool, error) {
+	var signedIn bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM auth_events
+			WHERE user_id = $1 AND event_type = $2 AND method = $3 AND success
+		)
+	`
+	err := db.QueryRow(query, userID, EventSignIn, middleware.AuthMethodPassword).Scan(&signedIn)
+	return signedIn, err
+}
+
+// ListUserAuthEventsFromStore returns the most recent events of one user.
+func ListUserAuthEventsFromStore(db *sql.DB, userID, limit int) ([]types.AuthEvent, error) {
+	query := `
+		SELECT ` + authEventColumns + `
+		FROM auth_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC, event_id DESC
+		LIMIT $2
+	`
+	rows, err := db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuthEvents(rows)
+}
+
+// ListOrgAuthEventsFromStore searches the sign-ins and sign-ups into an
+// organization, newest first, along with failed sign-ins of its members,
+// which happen before any organization is chosen. What members did in other
+// organizations isn't included.
+func ListOrgAuthEventsFromStore(db *sql.DB, orgID int, filter types.AuthEventFilter) ([]types.AuthEvent, error) {
+	var success sql.NullBool
+	if filter.Success != nil {
+		success = sql.NullBool{Bool: *filter.Success, Valid: true}
+	}
+
+	query := `
+		SELECT ` + authEventColumns + `
+		FROM auth_events e
+		WHERE (
+				e.org_id = $1
+				OR (e.org_id IS NULL AND e.user_id IN (SELECT user_id FROM org_members WHERE org_id = $1))
+			)
+			AND ($2 = 0 OR e.user_id = $2)
+			AND ($3 = '' OR e.email = LOWER($3))
+			AND ($4 = '' OR e.ip = $4)
+			AND ($5 = '' OR e.event_type = $5)
+			AND ($6::boolean IS NULL OR e.success = $6)
+			AND ($7::timestamp IS NULL OR e.created_at >= $7)
+			AND ($8::timestamp IS NULL OR e.created_at < $8)
+		ORDER BY e.created_at DESC, e.event_id DESC
+		LIMIT $9 OFFSET $10
+	`
+	rows, err := db.Query(
+		query,
+		orgID,
+		filter.UserID,
+		filter.Email,
+		filter.IP,
+		filter.Type,
+		success,
+		nullTime(filter.Since),
+		nullTime(filter.Until),
+		filter.Limit,
+		filter.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuthEvents(rows)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// Roles lists the known roles from most to least privileged.
+var Roles = []string{RoleAdmin, RoleMember, RoleViewer}
+
+// IsValidRole reports whether role is one of the known roles.
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type Action string
+
+const (
+	ActionList    Action = "list"
+	ActionRead    Action = "read"
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionAnalyze Action = "analyze"
+)
+
+type ResourceKind string
+
+const (
+	ResourceTask    ResourceKind = "task"
+	ResourceUser    ResourceKind = "user"
+	ResourceRole    ResourceKind = "role"
+	ResourceOrg     ResourceKind = "org"
+	ResourceProject ResourceKind = "project"
+)
+
+// Resource describes the object an action is performed on. OwnerID is the
+// creator of a task or the user a user/role resource belongs to.
+type Resource struct {
+	Kind       ResourceKind
+	OwnerID    int
+	AssigneeID int
+}
+
+func TaskResource(task types.Task) Resource {
+	return Resource{Kind: ResourceTask, OwnerID: task.CreatedBy, AssigneeID: task.AssignedTo}
+}
+
+func UserResource(userID int) Resource {
+	return Resource{Kind: ResourceUser, OwnerID: userID}
+}
+
+func RoleResource(userID int) Resource {
+	return Resource{Kind: ResourceRole, OwnerID: userID}
+}
+
+func OrgResource() Resource {
+	return Resource{Kind: ResourceOrg}
+}
+
+func ProjectResource(project types.Project) Resource {
+	return Resource{Kind: ResourceProject, OwnerID: project.OwnerID}
+}
+
+// Can reports whether the principal may perform action on the resource.
+// Roles are those held in the principal's active organization, and every
+// resource passed in is expected to belong to that organization. Admins may
+// do anything; viewers are read-only on shared data.
+func Can(p middleware.Principal, action Action, res Resource) bool {
+	if p.HasRole(RoleAdmin) {
+		return true
+	}
+
+	self := res.OwnerID != 0 && res.OwnerID == p.UserID
+	member := p.HasRole(RoleMember)
+
+	switch res.Kind {
+	case ResourceTask:
+		switch action {
+		case ActionList, ActionRead:
+			return member || p.HasRole(RoleViewer)
+		case ActionCreate:
+			return member
+		case ActionUpdate, ActionAnalyze:
+			return member && (self || res.AssigneeID == p.UserID)
+		case ActionDelete:
+			return member && self
+		}
+
+	case ResourceUser:
+		switch action {
+		case ActionList:
+			return true
+		case ActionRead, ActionUpdate, ActionDelete:
+			return self
+		}
+
+	case ResourceRole:
+		switch action {
+		case ActionList:
+			return true
+		case ActionRead:
+			return self
+		}
+
+	case ResourceProject:
+		switch action {
+		case ActionList, ActionRead:
+			return member || p.HasRole(RoleViewer)
+		case ActionCreate:
+			return member
+		case ActionUpdate, ActionDelete:
+			return member && self
+		}
+
+	case ResourceOrg:
+		switch action {
+		case ActionList, ActionRead:
+			return member || p.HasRole(RoleViewer)
+		case ActionCreate:
+			return true
+		}
+	}
+
+	return false
+}
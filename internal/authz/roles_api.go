@@ -0,0 +1,131 @@
+package authz
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetRoleRequest represents the request body for changing a user's role
+type SetRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// ListRoles returns the roles that can be assigned to users
+func ListRoles(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !Can(principal, ActionList, Resource{Kind: ResourceRole}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied",
+			})
+		}
+
+		roles, err := ListRolesFromStore(db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve roles",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(roles)
+	}
+}
+
+// GetUserRole returns the role of the user in the :id param within the active organization
+func GetUserRole(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !Can(principal, ActionRead, RoleResource(userID)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied",
+			})
+		}
+
+		role, err := GetUserRoleFromStore(db, principal.OrgID, userID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "User not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve role",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"user_id": userID,
+			"role":    role,
+		})
+	}
+}
+
+// SetUserRole changes the role of the user in the :id param within the active
+// organization. Admin only.
+func SetUserRole(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		var req SetRoleRequest
+		if err := c.BodyParser(&req); err != nil || !IsValidRole(req.Role) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid role",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !Can(principal, ActionUpdate, RoleResource(userID)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to manage roles",
+			})
+		}
+
+		// Keep at least one admin around
+		if userID == principal.UserID && req.Role != RoleAdmin {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Admins cannot demote themselves",
+			})
+		}
+
+		if err := SetUserRoleInStore(db, principal.OrgID, userID, req.Role); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "User not found",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update role",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"user_id": userID,
+			"role":    req.Role,
+		})
+	}
+}
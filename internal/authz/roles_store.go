@@ -0,0 +1,64 @@
+package authz
+
+import (
+	"database/sql"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+func ListRolesFromStore(db *sql.DB) ([]types.Role, error) {
+	query := `
+		SELECT name, description
+		FROM roles
+		ORDER BY rank
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []types.Role
+	for rows.Next() {
+		var role types.Role
+		if err := rows.Scan(&role.Name, &role.Description); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// GetUserRoleFromStore returns the role of a user in an organization, or
+// sql.ErrNoRows when the user is not an active member.
+func GetUserRoleFromStore(db *sql.DB, orgID, userID int) (string, error) {
+	var role string
+	query := `
+		SELECT m.role
+		FROM org_members m
+		JOIN users u ON u.user_id = m.user_id AND u.deactivated_at IS NULL
+		WHERE m.org_id = $1 AND m.user_id = $2 AND m.suspended_at IS NULL
+	`
+	err := db.QueryRow(query, orgID, userID).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func SetUserRoleInStore(db *sql.DB, orgID, userID int, role string) error {
+	query := `UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id = $3`
+	result, err := db.Exec(query, role, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
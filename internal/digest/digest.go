@@ -0,0 +1,179 @@
+package digest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const (
+	// staleAfter is how long a task may sit in progress untouched before
+	// it counts as stalled
+	staleAfter = 7 * 24 * time.Hour
+	// minOverloaded is the open task count at which a member is overloaded
+	minOverloaded = 8
+	// maxListed bounds each list of tasks described in a fallback summary
+	maxListed = 5
+	// maxHistory bounds the task changes passed on as they are; the
+	// prompt's context token budget may cut them down further
+	maxHistory = 100
+)
+
+// Sources of a project summary
+const (
+	SourceAI    = "ai"
+	SourceFacts = "facts"
+)
+
+// Summarize writes a status summary of the last days of project. The model
+// writes it when it's configured and principal's AI quota allows; its
+// summaries are cached by a hash of their input, so asking again before
+// anything changes costs nothing. Otherwise the summary is a plain listing
+// of the facts.
+func Summarize(ctx context.Context, db *sql.DB, principal middleware.Principal, project types.Project, days int) (types.ProjectSummary, error) {
+	now := time.Now().UTC()
+	since := now.AddDate(0, 0, -days)
+	today := now.Format(types.DueDateLayout)
+
+	facts, err := GetProjectFactsFromStore(db, project, since, now.Add(-staleAfter), today, minOverloaded, maxHistory)
+	if err != nil {
+		return types.ProjectSummary{}, err
+	}
+	facts.Days = days
+	facts.History = trimHistory(facts.History, ai.ContextTokenBudget())
+
+	summary := types.ProjectSummary{
+		ProjectID: project.ProjectID,
+		From:      since.Format(types.DueDateLayout),
+		To:        today,
+		Facts:     facts,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+
+	factsJSON, err := json.Marshal(facts)
+	if err != nil {
+		return types.ProjectSummary{}, err
+	}
+
+	if text, createdAt, ok := summarizeWithAI(ctx, db, principal, project, factsJSON); ok {
+		summary.Summary = text
+		summary.Source = SourceAI
+		if !createdAt.IsZero() {
+			summary.Cached = true
+			summary.CreatedAt = createdAt.Format(time.RFC3339)
+		}
+		return summary, nil
+	}
+
+	summary.Summary = factsSummary(facts)
+	summary.Source = SourceFacts
+	return summary, nil
+}
+
+// summarizeWithAI returns the cached summary for factsJSON along with when
+// it was made, or asks the model for a new one and a zero time. It reports
+// false when the model can't be used.
+func summarizeWithAI(ctx context.Context, db *sql.DB, principal middleware.Principal, project types.Project, factsJSON []byte) (string, time.Time, bool) {
+	gemini, err := ai.NewGeminiClient()
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	defer gemini.Close()
+
+	// The model is part of the input: a different one writes a
+	// different summary
+	sum := sha256.Sum256(append([]byte(gemini.ModelName()+"\n"+project.Name+"\n"), factsJSON...))
+	inputHash := hex.EncodeToString(sum[:])
+
+	text, createdAt, err := GetCachedSummaryFromStore(db, project.ProjectID, inputHash)
+	if err == nil {
+		return text, createdAt, true
+	}
+	if err != sql.ErrNoRows {
+		fmt.Println(err)
+	}
+
+	if err := ai.CheckQuotaInStore(db, principal.OrgID, principal.UserID); err != nil {
+		fmt.Println(err)
+		return "", time.Time{}, false
+	}
+
+	redactor, err := ai.GetOrgRedactor(db, project.OrgID)
+	if err != nil {
+		fmt.Println(err)
+		return "", time.Time{}, false
+	}
+
+	text, usage, err := gemini.SummarizeProject(ctx, project.Name, string(factsJSON), redactor)
+	ai.RecordUsage(db, principal, 0, ai.FeatureSummary, usage, err == nil)
+	if err != nil {
+		fmt.Println(err)
+		return "", time.Time{}, false
+	}
+
+	if err := SaveSummaryInStore(db, project.ProjectID, inputHash, gemini.ModelName(), text); err != nil {
+		fmt.Println(err)
+	}
+	return text, time.Time{}, true
+}
+
+// trimHistory keeps the newest changes that fit in budget tokens, at four
+// characters per token.
+func trimHistory(changes []types.TaskChange, budget int) []types.TaskChange {
+	for i, change := range changes {
+		line, _ := json.Marshal(change)
+		budget -= (len(line) + 3) / 4
+		if budget < 0 {
+			return changes[:i]
+		}
+	}
+	return changes
+}
+
+// factsSummary lists the facts as bullet points, for when there is no model.
+func factsSummary(facts types.ProjectFacts) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("- %d tasks created and %d changes in the last %d days.", facts.Created, facts.Changes, facts.Days))
+	if len(facts.Done) > 0 {
+		lines = append(lines, "- Done: "+taskList(facts.Done))
+	}
+	if len(facts.Blocked) > 0 {
+		lines = append(lines, "- Blocked or stalled: "+taskList(facts.Blocked))
+	}
+	if len(facts.Overdue) > 0 {
+		lines = append(lines, "- Overdue: "+taskList(facts.Overdue))
+	}
+	if len(facts.Overloaded) > 0 {
+		var names []string
+		for _, w := range facts.Overloaded {
+			names = append(names, fmt.Sprintf("%s (%d open)", w.Name, w.OpenTasks))
+		}
+		lines = append(lines, "- Overloaded: "+strings.Join(names, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func taskList(refs []types.TaskRef) string {
+	var names []string
+	for i, ref := range refs {
+		if i == maxListed {
+			names = append(names, fmt.Sprintf("and %d more", len(refs)-maxListed))
+			break
+		}
+		name := ref.Title
+		if ref.Key != "" {
+			name = ref.Key + " " + name
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
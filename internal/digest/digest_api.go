@@ -0,0 +1,69 @@
+package digest
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/projects"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultSummaryDays = 7
+	maxSummaryDays     = 30
+)
+
+// ProjectSummary returns a status summary of the project over the last
+// ?days= days (default 7, at most 30).
+func ProjectSummary(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid project ID",
+			})
+		}
+
+		days := c.QueryInt("days", defaultSummaryDays)
+		if days < 1 || days > maxSummaryDays {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("days must be between 1 and %d", maxSummaryDays),
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		project, err := projects.GetProjectFromStore(db, principal.OrgID, projectID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Project not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve project",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionRead, authz.ProjectResource(project)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view this project",
+			})
+		}
+
+		summary, err := Summarize(c.UserContext(), db, principal, project, days)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to summarize project",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(summary)
+	}
+}
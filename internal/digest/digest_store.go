@@ -0,0 +1,306 @@
+package digest
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/jobs"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// blockedPattern matches task text that says the task is blocked.
+const blockedPattern = `(blocked|blocker|waiting (on|for)|on hold)`
+
+// taskRefColumns selects a types.TaskRef from tasks t joined with the
+// assignee as u.
+const taskRefColumns = `
+	t.task_id, COALESCE(t.task_key, ''), t.title, t.status,
+	COALESCE(TRIM(u.first_name || ' ' || u.last_name), ''), COALESCE(TO_CHAR(t.due_date, 'YYYY-MM-DD'), '')
+`
+
+func queryTaskRefs(db *sql.DB, query string, args ...interface{}) ([]types.TaskRef, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []types.TaskRef{}
+	for rows.Next() {
+		var ref types.TaskRef
+		if err := rows.Scan(&ref.TaskID, &ref.Key, &ref.Title, &ref.Status, &ref.Assignee, &ref.DueDate); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// GetProjectFactsFromStore collects what happened in a project since the
+// given time. Tasks count as overdue when due before today, and as
+// blocked when their text says so or they sat in progress since
+// staleBefore. Overloaded members are those with at least minOverloaded
+// open tasks across the organization who have open tasks in the project.
+// The newest maxHistory task changes are included as they are.
+func GetProjectFactsFromStore(db *sql.DB, project types.Project, since, staleBefore time.Time, today string, minOverloaded, maxHistory int) (types.ProjectFacts, error) {
+	var facts types.ProjectFacts
+	var err error
+
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM tasks WHERE project_id = $1 AND created_at >= $2),
+			(SELECT COUNT(*) FROM task_updates tu JOIN tasks t ON t.task_id = tu.task_id
+				WHERE t.project_id = $1 AND tu.updated_at >= $2)
+	`
+	if err := db.QueryRow(query, project.ProjectID, since).Scan(&facts.Created, &facts.Changes); err != nil {
+		return facts, err
+	}
+
+	query = `
+		SELECT ` + taskRefColumns + `
+		FROM tasks t
+		LEFT JOIN users u ON u.user_id = t.assigned_to
+		WHERE t.project_id = $1 AND t.status = 'Done'
+			AND EXISTS (
+				SELECT 1 FROM task_updates tu
+				WHERE tu.task_id = t.task_id AND tu.change_type = 'Status'
+					AND tu.new_status = 'Done' AND tu.updated_at >= $2
+			)
+		ORDER BY t.task_id
+	`
+	if facts.Done, err = queryTaskRefs(db, query, project.ProjectID, since); err != nil {
+		return facts, err
+	}
+
+	query = `
+		SELECT ` + taskRefColumns + `
+		FROM tasks t
+		LEFT JOIN users u ON u.user_id = t.assigned_to
+		WHERE t.project_id = $1 AND t.status <> 'Done'
+			AND (
+				(t.title || ' ' || COALESCE(t.description, '')) ~* $2
+				OR (t.status = 'InProgress' AND t.updated_at < $3)
+			)
+		ORDER BY t.task_id
+	`
+	if facts.Blocked, err = queryTaskRefs(db, query, project.ProjectID, blockedPattern, staleBefore); err != nil {
+		return facts, err
+	}
+
+	query = `
+		SELECT ` + taskRefColumns + `
+		FROM tasks t
+		LEFT JOIN users u ON u.user_id = t.assigned_to
+		WHERE t.project_id = $1 AND t.status <> 'Done' AND t.due_date < $2::date
+		ORDER BY t.due_date, t.task_id
+	`
+	if facts.Overdue, err = queryTaskRefs(db, query, project.ProjectID, today); err != nil {
+		return facts, err
+	}
+
+	if facts.History, err = listTaskChanges(db, project.ProjectID, since, maxHistory); err != nil {
+		return facts, err
+	}
+
+	query = `
+		SELECT u.user_id, TRIM(u.first_name || ' ' || u.last_name), COUNT(*)
+		FROM tasks t
+		JOIN users u ON u.user_id = t.assigned_to
+		WHERE t.org_id = $1 AND t.status <> 'Done'
+			AND u.user_id IN (
+				SELECT assigned_to FROM tasks
+				WHERE project_id = $2 AND status <> 'Done' AND assigned_to IS NOT NULL
+			)
+		GROUP BY u.user_id, u.first_name, u.last_name
+		HAVING COUNT(*) >= $3
+		ORDER BY COUNT(*) DESC, u.user_id
+	`
+	rows, err := db.Query(query, project.OrgID, project.ProjectID, minOverloaded)
+	if err != nil {
+		return facts, err
+	}
+	defer rows.Close()
+
+	facts.Overloaded = []types.Workload{}
+	for rows.Next() {
+		var w types.Workload
+		if err := rows.Scan(&w.UserID, &w.Name, &w.OpenTasks); err != nil {
+			return facts, err
+		}
+		facts.Overloaded = append(facts.Overloaded, w)
+	}
+	return facts, rows.Err()
+}
+
+// listTaskChanges returns the newest limit changes to the project's tasks
+// since the given time.
+func listTaskChanges(db *sql.DB, projectID int, since time.Time, limit int) ([]types.TaskChange, error) {
+	query := `
+		SELECT
+			t.task_id, COALESCE(t.task_key, ''), t.title, tu.change_type,
+			CASE tu.change_type
+				WHEN 'Assignee' THEN COALESCE(TRIM(oa.first_name || ' ' || oa.last_name), '')
+				WHEN 'Status' THEN COALESCE(tu.old_status::text, '')
+				ELSE COALESCE(tu.old_priority::text, '')
+			END,
+			CASE tu.change_type
+				WHEN 'Assignee' THEN COALESCE(TRIM(na.first_name || ' ' || na.last_name), '')
+				WHEN 'Status' THEN COALESCE(tu.new_status::text, '')
+				ELSE COALESCE(tu.new_priority::text, '')
+			END,
+			COALESCE(TRIM(b.first_name || ' ' || b.last_name), ''),
+			tu.updated_at
+		FROM task_updates tu
+		JOIN tasks t ON t.task_id = tu.task_id
+		LEFT JOIN users b ON b.user_id = tu.user_id
+		LEFT JOIN users oa ON oa.user_id = tu.old_assignee
+		LEFT JOIN users na ON na.user_id = tu.new_assignee
+		WHERE t.project_id = $1 AND tu.updated_at >= $2
+		ORDER BY tu.updated_at DESC, tu.update_id DESC
+		LIMIT $3
+	`
+	rows, err := db.Query(query, projectID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []types.TaskChange{}
+	for rows.Next() {
+		var change types.TaskChange
+		var at time.Time
+		err := rows.Scan(
+			&change.TaskID,
+			&change.Key,
+			&change.Title,
+			&change.Field,
+			&change.From,
+			&change.To,
+			&change.By,
+			&at,
+		)
+		if err != nil {
+			return nil, err
+		}
+		change.At = at.UTC().Format(time.RFC3339)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// GetCachedSummaryFromStore returns the summary stored for the given input
+// hash, or sql.ErrNoRows.
+func GetCachedSummaryFromStore(db *sql.DB, projectID int, inputHash string) (string, time.Time, error) {
+	var summary string
+	var createdAt time.Time
+	query := `SELECT summary, created_at FROM project_summaries WHERE project_id = $1 AND input_hash = $2`
+	err := db.QueryRow(query, projectID, inputHash).Scan(&summary, &createdAt)
+	return summary, createdAt, err
+}
+
+func SaveSummaryInStore(db *sql.DB, projectID int, inputHash, model, summary string) error {
+	query := `
+		INSERT INTO project_summaries (project_id, input_hash, model, summary, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (project_id, input_hash) DO NOTHING
+	`
+	_, err := db.Exec(query, projectID, inputHash, model, summary)
+	return err
+}
+
+// ClaimDigestRunInStore claims the digest of the given day and queues the
+// job made by jobFor for every project active since the given time, in one
+// transaction, so only one instance sends it and a failure leaves the day
+// to be tried again. It returns how many digests were queued, 0 when the
+// day was already claimed.
+func ClaimDigestRunInStore(db *sql.DB, day string, since time.Time, jobFor func(types.Project) types.Job) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO digest_runs (digest_date) VALUES ($1::date) ON CONFLICT DO NOTHING`, day)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+
+	active, err := listActiveProjects(tx, since)
+	if err != nil {
+		return 0, err
+	}
+	for _, project := range active {
+		if _, err := jobs.EnqueueTx(tx, jobFor(project)); err != nil {
+			return 0, err
+		}
+	}
+	return len(active), tx.Commit()
+}
+
+// listActiveProjects returns the unarchived projects, across all
+// organizations, that had tasks created or changed since the given time.
+func listActiveProjects(tx *sql.Tx, since time.Time) ([]types.Project, error) {
+	query := `
+		SELECT p.project_id, p.org_id, p.name, p.key, p.owner_id
+		FROM projects p
+		WHERE NOT p.archived AND (
+			EXISTS (SELECT 1 FROM tasks t WHERE t.project_id = p.project_id AND t.created_at >= $1)
+			OR EXISTS (
+				SELECT 1 FROM task_updates tu JOIN tasks t ON t.task_id = tu.task_id
+				WHERE t.project_id = p.project_id AND tu.updated_at >= $1
+			)
+		)
+		ORDER BY p.project_id
+	`
+	rows, err := tx.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []types.Project
+	for rows.Next() {
+		var p types.Project
+		if err := rows.Scan(&p.ProjectID, &p.OrgID, &p.Name, &p.Key, &p.OwnerID); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// ListDigestRecipientsFromStore returns the emails of a project's owner and
+// of the active members with open tasks in it.
+func ListDigestRecipientsFromStore(db *sql.DB, project types.Project) ([]string, error) {
+	query := `
+		SELECT DISTINCT u.email
+		FROM users u
+		JOIN org_members m ON m.user_id = u.user_id AND m.org_id = $1
+		WHERE u.deactivated_at IS NULL AND m.suspended_at IS NULL AND (
+			u.user_id = $2
+			OR u.user_id IN (
+				SELECT assigned_to FROM tasks
+				WHERE project_id = $3 AND status <> 'Done' AND assigned_to IS NOT NULL
+			)
+		)
+		ORDER BY u.email
+	`
+	rows, err := db.Query(query, project.OrgID, project.OwnerID, project.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
@@ -0,0 +1,145 @@
+package digest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/jobs"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/notifier"
+	"github.com/adarsh-jaiss/zocket/internal/projects"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// JobDigest is the job kind that sends one project's daily digest.
+const JobDigest = "project_digest"
+
+const (
+	defaultDigestHour = 9
+	checkInterval     = time.Minute
+)
+
+type digestPayload struct {
+	ProjectID int    `json:"project_id"`
+	Day       string `json:"day"`
+}
+
+// RegisterJobs registers the digest job handler with the job queue.
+func RegisterJobs() {
+	jobs.Register(JobDigest, "digest", runDigestJob)
+}
+
+// digestHour is the UTC hour the daily digest goes out, DIGEST_HOUR or 9.
+// It reports false when DIGEST_HOUR is "off".
+func digestHour() (int, bool) {
+	v := os.Getenv("DIGEST_HOUR")
+	if v == "off" {
+		return 0, false
+	}
+	if hour, err := strconv.Atoi(v); err == nil && hour >= 0 && hour < 24 {
+		return hour, true
+	}
+	return defaultDigestHour, true
+}
+
+// StartScheduler queues the daily digest of every project that saw
+// activity in the last day, once the digest hour has passed. Instances
+// share the work through the job queue and claim each day in Postgres, so
+// every digest is sent once however many instances run.
+func StartScheduler(ctx context.Context, db *sql.DB) {
+	hour, ok := digestHour()
+	if !ok {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now().UTC()
+			if now.Hour() >= hour {
+				if err := queueDigests(db, now); err != nil {
+					fmt.Println(err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func queueDigests(db *sql.DB, now time.Time) error {
+	day := now.Format(types.DueDateLayout)
+	_, err := ClaimDigestRunInStore(db, day, now.AddDate(0, 0, -1), func(project types.Project) types.Job {
+		payload, _ := json.Marshal(digestPayload{ProjectID: project.ProjectID, Day: day})
+		return types.Job{
+			Kind:    JobDigest,
+			OrgID:   project.OrgID,
+			UserID:  project.OwnerID,
+			Payload: payload,
+		}
+	})
+	return err
+}
+
+// runDigestJob summarizes the last day of a project and sends it to the
+// project's owner and the members with open tasks in it. AI usage is
+// counted against the owner.
+func runDigestJob(ctx context.Context, db *sql.DB, job types.Job) (interface{}, error) {
+	var payload digestPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+
+	project, err := projects.GetProjectFromStore(db, job.OrgID, payload.ProjectID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, jobs.Permanent(errors.New("project not found"))
+		}
+		return nil, err
+	}
+
+	principal := middleware.Principal{UserID: job.UserID, OrgID: job.OrgID}
+	summary, err := Summarize(ctx, db, principal, project, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := ListDigestRecipientsFromStore(db, project)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrying would send the digest again to those who already got it,
+	// so failed deliveries are only logged
+	sent := 0
+	for _, to := range recipients {
+		err := notifier.GetNotifier().Notify(ctx, notifier.Message{
+			Kind:    "project_digest",
+			To:      to,
+			Subject: fmt.Sprintf("%s daily digest for %s", project.Name, payload.Day),
+			Body:    summary.Summary,
+		})
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		sent++
+	}
+
+	return map[string]interface{}{
+		"project_id": project.ProjectID,
+		"recipients": sent,
+		"source":     summary.Source,
+	}, nil
+}
@@ -0,0 +1,47 @@
+package history
+
+import (
+	"database/sql"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// TaskState is the part of a task whose changes are kept in task_updates.
+type TaskState struct {
+	Assignee int
+	Status   types.TaskStatus
+	Priority types.TaskPriority
+}
+
+// RecordTaskChangesInStore adds a task_updates row for each field that
+// differs between before and after.
+func RecordTaskChangesInStore(tx *sql.Tx, taskID, userID int, before, after TaskState) error {
+	if before.Assignee != after.Assignee {
+		query := `
+			INSERT INTO task_updates (task_id, user_id, change_type, old_assignee, new_assignee, updated_at)
+			VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), NOW())
+		`
+		if _, err := tx.Exec(query, taskID, userID, types.Assignee, before.Assignee, after.Assignee); err != nil {
+			return err
+		}
+	}
+	if before.Status != after.Status {
+		query := `
+			INSERT INTO task_updates (task_id, user_id, change_type, old_status, new_status, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`
+		if _, err := tx.Exec(query, taskID, userID, types.Status, before.Status, after.Status); err != nil {
+			return err
+		}
+	}
+	if before.Priority != after.Priority {
+		query := `
+			INSERT INTO task_updates (task_id, user_id, change_type, old_priority, new_priority, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`
+		if _, err := tx.Exec(query, taskID, userID, types.Priority, before.Priority, after.Priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
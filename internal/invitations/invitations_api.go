@@ -0,0 +1,357 @@
+package invitations
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/notifier"
+	"github.com/adarsh-jaiss/zocket/internal/projects"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// DefaultInviteTTLHours is used when INVITE_TTL_HOURS is not set.
+	DefaultInviteTTLHours = 72
+
+	inviteAudience = "zocket-invite"
+)
+
+// InviteClaims is the signed payload of an invitation link. RegisteredClaims.ID
+// holds the invitation's current nonce.
+type InviteClaims struct {
+	InvitationID int    `json:"inv"`
+	Email        string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func (c InviteClaims) Valid() error {
+	if err := c.RegisteredClaims.Valid(); err != nil {
+		return err
+	}
+	if !c.VerifyAudience(inviteAudience, true) || c.InvitationID == 0 || c.ID == "" {
+		return ErrInvitationInvalid
+	}
+	return nil
+}
+
+// CreateInvitationRequest represents the request body for inviting someone
+type CreateInvitationRequest struct {
+	Email     string `json:"email" validate:"required,email"`
+	Role      string `json:"role"`
+	ProjectID int    `json:"project_id"`
+}
+
+// AcceptInvitationRequest represents the request body for accepting an
+// invitation with an existing account
+type AcceptInvitationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ParseInviteToken verifies the signature, audience and expiry of an
+// invitation token.
+func ParseInviteToken(token string) (*InviteClaims, error) {
+	claims := &InviteClaims{}
+	if err := middleware.ParseClaims(token, claims); err != nil {
+		return nil, ErrInvitationInvalid
+	}
+	return claims, nil
+}
+
+// AcceptInvitation joins userID to the organization of the invitation in
+// token. The invitation must have been sent to email.
+func AcceptInvitation(db *sql.DB, token string, userID int, email string) (types.Invitation, error) {
+	claims, err := ParseInviteToken(token)
+	if err != nil {
+		return types.Invitation{}, err
+	}
+	if !strings.EqualFold(claims.Email, email) {
+		return types.Invitation{}, ErrInvitationInvalid
+	}
+	return AcceptInvitationInStore(db, claims, userID, email)
+}
+
+// CreateInvitation invites an email address to the active organization,
+// optionally pointing at one of its projects. Admin only.
+func CreateInvitation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req CreateInvitationRequest
+		if err := c.BodyParser(&req); err != nil || !strings.Contains(req.Email, "@") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if req.Role == "" {
+			req.Role = authz.RoleMember
+		}
+		if !authz.IsValidRole(req.Role) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid role",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to invite members",
+			})
+		}
+
+		if req.ProjectID != 0 {
+			if _, err := projects.GetProjectFromStore(db, principal.OrgID, req.ProjectID); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Project not found",
+				})
+			}
+		}
+
+		nonce, expiresAt, err := newNonce()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create invitation",
+			})
+		}
+
+		inv, err := CreateInvitationInStore(db, types.Invitation{
+			OrgID:     principal.OrgID,
+			ProjectID: req.ProjectID,
+			Email:     req.Email,
+			Role:      req.Role,
+			InvitedBy: principal.UserID,
+		}, nonce, expiresAt)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create invitation",
+			})
+		}
+
+		return sendInvitation(c, inv, nonce, expiresAt, fiber.StatusCreated)
+	}
+}
+
+// ListInvitations returns the active organization's invitations. Pass
+// ?status=pending|accepted|revoked|expired to filter. Admin only.
+func ListInvitations(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view invitations",
+			})
+		}
+
+		status := types.InvitationStatus(c.Query("status"))
+		invitations, err := ListInvitationsFromStore(db, principal.OrgID, status)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve invitations",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(invitations)
+	}
+}
+
+// ResendInvitation issues a fresh link with a new expiry. Links sent before
+// stop working. Admin only.
+func ResendInvitation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		invitationID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid invitation ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to manage invitations",
+			})
+		}
+
+		if _, err := GetInvitationFromStore(db, principal.OrgID, invitationID); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Invitation not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve invitation",
+			})
+		}
+
+		nonce, expiresAt, err := newNonce()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to resend invitation",
+			})
+		}
+
+		inv, err := RenewInvitationInStore(db, principal.OrgID, invitationID, nonce, expiresAt)
+		if err != nil {
+			if err == ErrInvitationClosed {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Invitation was already accepted or revoked",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to resend invitation",
+			})
+		}
+
+		return sendInvitation(c, inv, nonce, expiresAt, fiber.StatusOK)
+	}
+}
+
+// RevokeInvitation invalidates a pending invitation. Admin only.
+func RevokeInvitation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		invitationID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid invitation ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to manage invitations",
+			})
+		}
+
+		if err := RevokeInvitationInStore(db, principal.OrgID, invitationID); err != nil {
+			if err == ErrInvitationClosed {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Invitation not found or no longer pending",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to revoke invitation",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Invitation revoked successfully",
+		})
+	}
+}
+
+// AcceptInvitationHandler lets a signed-in user accept an invitation sent to
+// their email address.
+func AcceptInvitationHandler(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req AcceptInvitationRequest
+		if err := c.BodyParser(&req); err != nil || req.Token == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		inv, err := AcceptInvitation(db, req.Token, principal.UserID, principal.Email)
+		if err != nil {
+			if err == ErrInvitationInvalid {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid or expired invitation",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to accept invitation",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(inv)
+	}
+}
+
+// sendInvitation signs the invitation link, hands it to the notifier and
+// returns it to the admin so it can also be shared by hand.
+func sendInvitation(c *fiber.Ctx, inv types.Invitation, nonce string, expiresAt time.Time, status int) error {
+	token, err := middleware.SignClaims(InviteClaims{
+		InvitationID: inv.InvitationID,
+		Email:        inv.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        nonce,
+			Audience:  jwt.ClaimStrings{inviteAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign invitation",
+		})
+	}
+
+	link := inviteURL(token)
+	err = notifier.GetNotifier().Notify(context.Background(), notifier.Message{
+		Kind:    "invitation",
+		To:      inv.Email,
+		Subject: "You have been invited to a Zocket workspace",
+		Body:    fmt.Sprintf("You have been invited as %s. Accept before %s: %s", inv.Role, inv.ExpiresAt, link),
+	})
+	if err != nil {
+		// The link is still returned so the admin can share it by hand
+		fmt.Println(err)
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"invitation": inv,
+		"token":      token,
+		"invite_url": link,
+		"delivered":  err == nil,
+	})
+}
+
+func newNonce() (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+
+	ttl := DefaultInviteTTLHours
+	if hours, err := strconv.Atoi(os.Getenv("INVITE_TTL_HOURS")); err == nil && hours > 0 {
+		ttl = hours
+	}
+	return hex.EncodeToString(b), time.Now().Add(time.Hour * time.Duration(ttl)), nil
+}
+
+func inviteURL(token string) string {
+	base := os.Getenv("INVITE_URL_BASE")
+	if base == "" {
+		base = "http://localhost:3000/signup"
+	}
+	return base + "?invite=" + url.QueryEscape(token)
+}
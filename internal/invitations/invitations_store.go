@@ -0,0 +1,216 @@
+package invitations
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+var (
+	ErrInvitationClosed  = errors.New("invitation was already accepted or revoked")
+	ErrInvitationInvalid = errors.New("invitation is invalid or expired")
+)
+
+const invitationColumns = `
+	invitation_id, org_id, COALESCE(project_id, 0), email, role, invited_by, sent_count,
+	expires_at, accepted_at, revoked_at, created_at,
+	CASE
+		WHEN revoked_at IS NOT NULL THEN 'revoked'
+		WHEN accepted_at IS NOT NULL THEN 'accepted'
+		WHEN expires_at < NOW() THEN 'expired'
+		ELSE 'pending'
+	END
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInvitation(row rowScanner) (types.Invitation, error) {
+	var inv types.Invitation
+	var acceptedAt, revokedAt sql.NullTime
+	var expiresAt time.Time
+	err := row.Scan(
+		&inv.InvitationID,
+		&inv.OrgID,
+		&inv.ProjectID,
+		&inv.Email,
+		&inv.Role,
+		&inv.InvitedBy,
+		&inv.SentCount,
+		&expiresAt,
+		&acceptedAt,
+		&revokedAt,
+		&inv.CreatedAt,
+		&inv.Status,
+	)
+	if err != nil {
+		return types.Invitation{}, err
+	}
+
+	inv.ExpiresAt = expiresAt.Format(time.RFC3339)
+	if acceptedAt.Valid {
+		inv.AcceptedAt = acceptedAt.Time.Format(time.RFC3339)
+	}
+	if revokedAt.Valid {
+		inv.RevokedAt = revokedAt.Time.Format(time.RFC3339)
+	}
+	return inv, nil
+}
+
+// CreateInvitationInStore saves a new invitation. nonce is embedded in the
+// signed token so that resending invalidates earlier links.
+func CreateInvitationInStore(db *sql.DB, inv types.Invitation, nonce string, expiresAt time.Time) (types.Invitation, error) {
+	query := `
+		INSERT INTO invitations (org_id, project_id, email, role, invited_by, nonce, sent_count, expires_at, created_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, 1, $7, NOW())
+		RETURNING ` + invitationColumns
+	return scanInvitation(db.QueryRow(
+		query,
+		inv.OrgID,
+		inv.ProjectID,
+		strings.ToLower(inv.Email),
+		inv.Role,
+		inv.InvitedBy,
+		nonce,
+		expiresAt,
+	))
+}
+
+func GetInvitationFromStore(db *sql.DB, orgID, invitationID int) (types.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE invitation_id = $1 AND org_id = $2`
+	return scanInvitation(db.QueryRow(query, invitationID, orgID))
+}
+
+// ListInvitationsFromStore returns an organization's invitations, optionally
+// only those in the given status.
+func ListInvitationsFromStore(db *sql.DB, orgID int, status types.InvitationStatus) ([]types.Invitation, error) {
+	query := `
+		SELECT * FROM (
+			SELECT ` + invitationColumns + ` AS status
+			FROM invitations
+			WHERE org_id = $1
+		) i
+		WHERE $2 = '' OR i.status = $2
+		ORDER BY i.created_at DESC
+	`
+	rows, err := db.Query(query, orgID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []types.Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, nil
+}
+
+// RenewInvitationInStore rotates the nonce and expiry of an invitation that
+// has not been accepted or revoked yet.
+func RenewInvitationInStore(db *sql.DB, orgID, invitationID int, nonce string, expiresAt time.Time) (types.Invitation, error) {
+	query := `
+		UPDATE invitations
+		SET nonce = $1, expires_at = $2, sent_count = sent_count + 1
+		WHERE invitation_id = $3 AND org_id = $4 AND accepted_at IS NULL AND revoked_at IS NULL
+		RETURNING ` + invitationColumns
+	inv, err := scanInvitation(db.QueryRow(query, nonce, expiresAt, invitationID, orgID))
+	if err == sql.ErrNoRows {
+		return types.Invitation{}, ErrInvitationClosed
+	}
+	return inv, err
+}
+
+func RevokeInvitationInStore(db *sql.DB, orgID, invitationID int) error {
+	query := `
+		UPDATE invitations SET revoked_at = NOW()
+		WHERE invitation_id = $1 AND org_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+	`
+	result, err := db.Exec(query, invitationID, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvitationClosed
+	}
+	return nil
+}
+
+// CheckInvitationInStore returns ErrInvitationInvalid unless the invitation
+// in claims is still pending for email.
+func CheckInvitationInStore(db *sql.DB, claims *InviteClaims, email string) error {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE invitation_id = $1 AND nonce = $2`
+	inv, err := scanInvitation(db.QueryRow(query, claims.InvitationID, claims.ID))
+	if err == sql.ErrNoRows {
+		return ErrInvitationInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if inv.Status != types.InvitationPending || !strings.EqualFold(inv.Email, email) {
+		return ErrInvitationInvalid
+	}
+	return nil
+}
+
+// AcceptInvitationInStore adds the user to the invitation's organization
+// with the invited role and marks the invitation accepted. Users who are
+// already members keep their current role.
+func AcceptInvitationInStore(db *sql.DB, claims *InviteClaims, userID int, email string) (types.Invitation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Invitation{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + invitationColumns + `
+		FROM invitations
+		WHERE invitation_id = $1 AND nonce = $2
+		FOR UPDATE
+	`
+	inv, err := scanInvitation(tx.QueryRow(query, claims.InvitationID, claims.ID))
+	if err == sql.ErrNoRows {
+		return types.Invitation{}, ErrInvitationInvalid
+	}
+	if err != nil {
+		return types.Invitation{}, err
+	}
+	if inv.Status != types.InvitationPending || !strings.EqualFold(inv.Email, email) {
+		return types.Invitation{}, ErrInvitationInvalid
+	}
+
+	query = `
+		INSERT INTO org_members (org_id, user_id, role, joined_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (org_id, user_id) DO NOTHING
+	`
+	if _, err := tx.Exec(query, inv.OrgID, userID, inv.Role); err != nil {
+		return types.Invitation{}, err
+	}
+
+	query = `UPDATE invitations SET accepted_at = NOW(), accepted_by = $1 WHERE invitation_id = $2`
+	if _, err := tx.Exec(query, userID, inv.InvitationID); err != nil {
+		return types.Invitation{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Invitation{}, err
+	}
+
+	inv.Status = types.InvitationAccepted
+	return inv, nil
+}
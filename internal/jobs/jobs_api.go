@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetJob returns a job's status and, once it succeeded, its result. Jobs
+// are visible to the user who started them and to org admins.
+func GetJob(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		jobID, err := c.ParamsInt("id")
+		if err != nil || jobID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid job ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		job, err := GetJobFromStore(db, principal.OrgID, int64(jobID))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Job not found",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve job",
+			})
+		}
+
+		if job.UserID != principal.UserID && !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Job not found",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(job)
+	}
+}
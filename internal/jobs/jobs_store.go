@@ -0,0 +1,192 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const jobColumns = `
+	job_id, kind, org_id, user_id, COALESCE(task_id, 0), payload, status, attempts, max_attempts,
+	run_at, COALESCE(last_error, ''), result, created_at, updated_at, finished_at
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (types.Job, error) {
+	var job types.Job
+	var payload, result []byte
+	var runAt, createdAt, updatedAt time.Time
+	var finishedAt sql.NullTime
+	err := row.Scan(
+		&job.JobID,
+		&job.Kind,
+		&job.OrgID,
+		&job.UserID,
+		&job.TaskID,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&runAt,
+		&job.LastError,
+		&result,
+		&createdAt,
+		&updatedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return types.Job{}, err
+	}
+
+	job.Payload = json.RawMessage(payload)
+	if len(result) > 0 {
+		job.Result = json.RawMessage(result)
+	}
+	job.RunAt = runAt.Format(time.RFC3339)
+	job.CreatedAt = createdAt.Format(time.RFC3339)
+	job.UpdatedAt = updatedAt.Format(time.RFC3339)
+	if finishedAt.Valid {
+		job.FinishedAt = finishedAt.Time.Format(time.RFC3339)
+	}
+	return job, nil
+}
+
+// EnqueueInStore adds a job to the queue. When the same user already has a
+// job of the same kind for the same task with the same payload queued or
+// running, that one is returned instead and created is false. Jobs of other
+// users are never shared, since only the requester can read them and gets
+// their events.
+func EnqueueInStore(db *sql.DB, job types.Job) (types.Job, bool, error) {
+	payload := []byte(job.Payload)
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Job{}, false, err
+	}
+	defer tx.Rollback()
+
+	if job.TaskID != 0 {
+		// Serialize enqueues per task so two requests can't both miss the
+		// other's job
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1), $2)`, job.Kind, job.TaskID); err != nil {
+			return types.Job{}, false, err
+		}
+
+		query := `
+			SELECT ` + jobColumns + `
+			FROM jobs
+			WHERE kind = $1 AND task_id = $2 AND status IN ('queued', 'running')
+				AND user_id = $3 AND payload = $4::jsonb
+			ORDER BY job_id
+			LIMIT 1
+		`
+		existing, err := scanJob(tx.QueryRow(query, job.Kind, job.TaskID, job.UserID, payload))
+		if err == nil {
+			return existing, false, tx.Commit()
+		}
+		if err != sql.ErrNoRows {
+			return types.Job{}, false, err
+		}
+	}
+
+	created, err := InsertJobInStore(tx, job)
+	if err != nil {
+		return types.Job{}, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Job{}, false, err
+	}
+	return created, true, nil
+}
+
+// InsertJobInStore queues a job within tx.
+func InsertJobInStore(tx *sql.Tx, job types.Job) (types.Job, error) {
+	payload := []byte(job.Payload)
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO jobs (kind, org_id, user_id, task_id, payload, status, attempts, max_attempts, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, 'queued', 0, $6, NOW(), NOW(), NOW())
+		RETURNING ` + jobColumns
+	return scanJob(tx.QueryRow(
+		query,
+		job.Kind,
+		job.OrgID,
+		job.UserID,
+		job.TaskID,
+		payload,
+		job.MaxAttempts,
+	))
+}
+
+func GetJobFromStore(db *sql.DB, orgID int, jobID int64) (types.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE job_id = $1 AND org_id = $2`
+	return scanJob(db.QueryRow(query, jobID, orgID))
+}
+
+// ClaimJobFromStore marks the next due job as running and returns it, or
+// returns sql.ErrNoRows when nothing is due. Jobs stuck in running for
+// longer than staleAfter, e.g. because their worker died, are picked up
+// again.
+func ClaimJobFromStore(db *sql.DB, staleAfter time.Duration) (types.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+		WHERE job_id = (
+			SELECT job_id FROM jobs
+			WHERE (status = 'queued' AND run_at <= NOW())
+				OR (status = 'running' AND locked_at < NOW() - make_interval(secs => $1))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+	return scanJob(db.QueryRow(query, staleAfter.Seconds()))
+}
+
+// CompleteJobInStore marks a job as succeeded with its result.
+func CompleteJobInStore(db *sql.DB, jobID int64, result []byte) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', result = $2, last_error = NULL, locked_at = NULL,
+			updated_at = NOW(), finished_at = NOW()
+		WHERE job_id = $1
+	`
+	_, err := db.Exec(query, jobID, result)
+	return err
+}
+
+// RetryJobInStore puts a failed job back in the queue to run after delay.
+func RetryJobInStore(db *sql.DB, jobID int64, lastError string, delay time.Duration) error {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', last_error = $2, locked_at = NULL,
+			run_at = NOW() + make_interval(secs => $3), updated_at = NOW()
+		WHERE job_id = $1
+	`
+	_, err := db.Exec(query, jobID, lastError, delay.Seconds())
+	return err
+}
+
+// FailJobInStore gives up on a job.
+func FailJobInStore(db *sql.DB, jobID int64, lastError string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'failed', last_error = $2, locked_at = NULL,
+			updated_at = NOW(), finished_at = NOW()
+		WHERE job_id = $1
+	`
+	_, err := db.Exec(query, jobID, lastError)
+	return err
+}
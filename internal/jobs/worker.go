@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/websocket"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const (
+	defaultWorkers     = 2
+	defaultMaxAttempts = 3
+	pollInterval       = time.Second
+	staleAfter         = 10 * time.Minute
+	baseBackoff        = 5 * time.Second
+	maxBackoff         = 5 * time.Minute
+)
+
+// RunFunc does the work of a job and returns its result, which is stored
+// as JSON and sent to the requester.
+type RunFunc func(ctx context.Context, db *sql.DB, job types.Job) (interface{}, error)
+
+type handler struct {
+	event string
+	run   RunFunc
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]handler{}
+)
+
+// Register sets the function that runs jobs of kind. Progress is sent to
+// the requester as "<event>_started", "<event>_completed" and
+// "<event>_failed" websocket messages.
+func Register(kind, event string, run RunFunc) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[kind] = handler{event: event, run: run}
+}
+
+func getHandler(kind string) (handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	h, ok := handlers[kind]
+	return h, ok
+}
+
+// permanentError marks a failure that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job fails right away instead of retrying.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// retryAtError asks for the next attempt no earlier than at.
+type retryAtError struct {
+	err error
+	at  time.Time
+}
+
+func (e retryAtError) Error() string { return e.err.Error() }
+func (e retryAtError) Unwrap() error { return e.err }
+
+// RetryAt wraps err so the next attempt waits until at if that's later
+// than the usual backoff, e.g. while a dependency is known to be down.
+func RetryAt(err error, at time.Time) error {
+	return retryAtError{err: err, at: at}
+}
+
+// Enqueue queues a job with the default number of attempts.
+func Enqueue(db *sql.DB, job types.Job) (types.Job, bool, error) {
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = defaultMaxAttempts
+	}
+	return EnqueueInStore(db, job)
+}
+
+// EnqueueTx queues a job with the default number of attempts as part of
+// tx, so it only runs if tx commits. Queued jobs are never reused.
+func EnqueueTx(tx *sql.Tx, job types.Job) (types.Job, error) {
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = defaultMaxAttempts
+	}
+	return InsertJobInStore(tx, job)
+}
+
+// StartWorkers starts JOB_WORKERS (default 2) goroutines that run queued
+// jobs until ctx is cancelled. Setting it to 0 disables workers in this
+// process, e.g. on API-only replicas.
+func StartWorkers(ctx context.Context, db *sql.DB) {
+	n := defaultWorkers
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			fmt.Printf("invalid JOB_WORKERS %q, using %d\n", v, defaultWorkers)
+		} else {
+			n = parsed
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		go work(ctx, db)
+	}
+}
+
+func work(ctx context.Context, db *sql.DB) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain everything that's due before waiting for the next tick
+		for {
+			job, err := ClaimJobFromStore(db, staleAfter)
+			if err == sql.ErrNoRows {
+				break
+			}
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+			process(ctx, db, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func process(ctx context.Context, db *sql.DB, job types.Job) {
+	h, ok := getHandler(job.Kind)
+	if !ok {
+		if err := FailJobInStore(db, job.JobID, "unknown job kind "+job.Kind); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	Notify(job, h.event+"_started", nil)
+
+	result, err := h.run(ctx, db, job)
+	if err == nil {
+		resultJSON, merr := json.Marshal(result)
+		if merr != nil {
+			err = Permanent(merr)
+		} else if err = CompleteJobInStore(db, job.JobID, resultJSON); err == nil {
+			Notify(job, h.event+"_completed", map[string]interface{}{"result": result})
+			return
+		}
+	}
+
+	fmt.Printf("job %d (%s) attempt %d failed: %v\n", job.JobID, job.Kind, job.Attempts, err)
+
+	var permanent permanentError
+	lastError := err.Error()
+	willRetry := !errors.As(err, &permanent) && job.Attempts < job.MaxAttempts
+	if willRetry {
+		delay := backoff(job.Attempts)
+		var retryAt retryAtError
+		if errors.As(err, &retryAt) && time.Until(retryAt.at) > delay {
+			delay = time.Until(retryAt.at)
+		}
+		err = RetryJobInStore(db, job.JobID, lastError, delay)
+	} else {
+		err = FailJobInStore(db, job.JobID, lastError)
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	Notify(job, h.event+"_failed", map[string]interface{}{
+		"error":      lastError,
+		"will_retry": willRetry,
+	})
+}
+
+// backoff doubles the delay with each attempt and adds up to 50% jitter so
+// jobs that failed together don't retry together.
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func Notify(job types.Job, event string, extra map[string]interface{}) {
+	data := map[string]interface{}{
+		"job_id":  job.JobID,
+		"kind":    job.Kind,
+		"task_id": job.TaskID,
+		"attempt": job.Attempts,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type": event,
+		"data": data,
+	})
+	websocket.GetManager().SendToUser(job.UserID, msg)
+}
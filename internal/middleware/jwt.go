@@ -1,32 +1,126 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	jwtware "github.com/gofiber/jwt/v3"
+	"github.com/golang-jwt/jwt/v4"
 )
 
-var JWTSecret = os.Getenv("JWT_SECRET")
-
 const (
 	JWTExpirationHours = 24
+
+	// Defaults used when JWT_ISSUER / JWT_AUDIENCE are not set.
+	DefaultJWTIssuer   = "zocket"
+	DefaultJWTAudience = "zocket-api"
+)
+
+var (
+	ErrInvalidIssuer   = errors.New("token has invalid issuer")
+	ErrInvalidAudience = errors.New("token has invalid audience")
+	ErrMissingUserID   = errors.New("token has no user id")
 )
 
+// JWTClaim is the claim set carried by every access token issued by the API.
 type JWTClaim struct {
-	UserID    int       `json:"user_id"`
-	Email     string    `json:"email"`
-	ExpiresAt time.Time `json:"expires_at"`
+	UserID     int      `json:"user_id"`
+	Email      string   `json:"email"`
+	Roles      []string `json:"roles,omitempty"`
+	OrgID      int      `json:"org_id,omitempty"`
+	AuthMethod string   `json:"amr,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Valid checks expiry as well as the issuer and audience the API expects.
+func (c JWTClaim) Valid() error {
+	if err := c.RegisteredClaims.Valid(); err != nil {
+		return err
+	}
+	if !c.VerifyIssuer(jwtIssuer(), true) {
+		return ErrInvalidIssuer
+	}
+	if !c.VerifyAudience(jwtAudience(), true) {
+		return ErrInvalidAudience
+	}
+	if c.UserID == 0 {
+		return ErrMissingUserID
+	}
+	return nil
+}
+
+// GenerateToken signs an access token for the given principal.
+func GenerateToken(p Principal) (string, error) {
+	if p.AuthMethod == "" {
+		p.AuthMethod = AuthMethodPassword
+	}
+
+	now := time.Now()
+	claims := JWTClaim{
+		UserID:     p.UserID,
+		Email:      p.Email,
+		Roles:      p.Roles,
+		OrgID:      p.OrgID,
+		AuthMethod: p.AuthMethod,
+		Scopes:     p.Scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer(),
+			Audience:  jwt.ClaimStrings{jwtAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour * time.Duration(JWTExpirationHours))),
+		},
+	}
+
+	return SignClaims(claims)
+}
+
+// SignClaims signs arbitrary claims with the API secret. It is used for
+// tokens other than access tokens, such as invitations.
+func SignClaims(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret())
+}
+
+// ParseClaims verifies a token produced by SignClaims into claims.
+func ParseClaims(tokenString string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return jwtSecret(), nil
+	})
+	return err
 }
 
 func JWTProtected() fiber.Handler {
 	return jwtware.New(jwtware.Config{
-		SigningKey:   []byte(JWTSecret),
-		ErrorHandler: jwtError,
+		SigningKey:     jwtSecret(),
+		Claims:         &JWTClaim{},
+		SuccessHandler: setPrincipal,
+		ErrorHandler:   jwtError,
 	})
 }
 
+// setPrincipal resolves the verified token into a Principal once so that
+// handlers never need to look at raw claims.
+func setPrincipal(c *fiber.Ctx) error {
+	token, ok := c.Locals("user").(*jwt.Token)
+	if !ok {
+		return jwtError(c, ErrMissingUserID)
+	}
+	claims, ok := token.Claims.(*JWTClaim)
+	if !ok {
+		return jwtError(c, ErrMissingUserID)
+	}
+
+	c.Locals(PrincipalKey, principalFromClaims(claims))
+	return c.Next()
+}
+
 func jwtError(c *fiber.Ctx, err error) error {
 	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 		"error":   "Unauthorized",
@@ -34,3 +128,22 @@ func jwtError(c *fiber.Ctx, err error) error {
 	})
 }
 
+// The secret and token metadata are read lazily so that values loaded from
+// .env in main's init are picked up.
+func jwtSecret() []byte {
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func jwtIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return DefaultJWTIssuer
+}
+
+func jwtAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return DefaultJWTAudience
+}
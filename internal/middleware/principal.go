@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PrincipalKey is the fiber.Ctx locals key holding the authenticated Principal.
+const PrincipalKey = "principal"
+
+const (
+	AuthMethodPassword = "password"
+	AuthMethodOIDC     = "oidc"
+)
+
+var ErrNoPrincipal = errors.New("no authenticated principal in context")
+
+// Principal is the authenticated caller of a protected route.
+type Principal struct {
+	UserID     int      `json:"user_id"`
+	Email      string   `json:"email"`
+	Roles      []string `json:"roles,omitempty"`
+	OrgID      int      `json:"org_id,omitempty"`
+	AuthMethod string   `json:"auth_method"`
+	Scopes     []string `json:"scopes,omitempty"`
+	// IssuedAt is when the caller's token was issued
+	IssuedAt time.Time `json:"-"`
+}
+
+// HasRole reports whether the principal holds the given role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the principal's token was granted the given scope.
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPrincipal returns the principal set by JWTProtected.
+func GetPrincipal(c *fiber.Ctx) (Principal, error) {
+	p, ok := c.Locals(PrincipalKey).(Principal)
+	if !ok {
+		return Principal{}, ErrNoPrincipal
+	}
+	return p, nil
+}
+
+// SetPrincipal replaces the principal for the rest of the request.
+func SetPrincipal(c *fiber.Ctx, p Principal) {
+	c.Locals(PrincipalKey, p)
+}
+
+// Unauthorized is the response sent when a handler cannot resolve a principal.
+func Unauthorized(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": "Unauthorized",
+	})
+}
+
+func principalFromClaims(claims *JWTClaim) Principal {
+	p := Principal{
+		UserID:     claims.UserID,
+		Email:      claims.Email,
+		Roles:      claims.Roles,
+		OrgID:      claims.OrgID,
+		AuthMethod: claims.AuthMethod,
+		Scopes:     claims.Scopes,
+	}
+	if claims.IssuedAt != nil {
+		p.IssuedAt = claims.IssuedAt.Time
+	}
+	return p
+}
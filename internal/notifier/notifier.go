@@ -0,0 +1,87 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Message is a notification addressed to a person, e.g. an invitation email.
+type Message struct {
+	Kind    string `json:"kind"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to people outside of the websocket connection.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier prints messages to stdout. It is used when no delivery channel
+// is configured, which is handy in development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	fmt.Printf("[notifier] %s to %s: %s\n%s\n", msg.Kind, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// WebhookNotifier posts messages as JSON to a URL, e.g. a mail relay or a
+// Slack bridge.
+type WebhookNotifier struct {
+	URL    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+var notifier Notifier
+
+// InitNotifier picks the webhook notifier when NOTIFIER_WEBHOOK_URL is set
+// and falls back to logging otherwise.
+func InitNotifier() {
+	if url := os.Getenv("NOTIFIER_WEBHOOK_URL"); url != "" {
+		notifier = NewWebhookNotifier(url)
+		return
+	}
+	notifier = LogNotifier{}
+}
+
+func GetNotifier() Notifier {
+	return notifier
+}
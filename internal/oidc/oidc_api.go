@@ -0,0 +1,432 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/audit"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/notifier"
+	"github.com/adarsh-jaiss/zocket/internal/org"
+	users "github.com/adarsh-jaiss/zocket/internal/user"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	stateCookie   = "zocket_oidc"
+	stateAudience = "zocket-oidc-state"
+	stateTTL      = 10 * time.Minute
+
+	maxNameLength = 50
+)
+
+var (
+	errUnverifiedEmail = errors.New("identity provider did not verify the email address")
+	errDeactivated     = errors.New("account is deactivated")
+)
+
+// stateClaims travel in a signed, short-lived cookie between the login
+// redirect and the callback, so any replica can finish the flow.
+type stateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+func (c stateClaims) Valid() error {
+	if err := c.RegisteredClaims.Valid(); err != nil {
+		return err
+	}
+	if !c.VerifyAudience(stateAudience, true) || c.State == "" || c.Verifier == "" {
+		return jwt.ErrTokenInvalidClaims
+	}
+	return nil
+}
+
+// Login starts the authorization-code flow with PKCE by redirecting to the
+// identity provider.
+func Login() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p, err := GetProvider()
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "OIDC login is not configured",
+			})
+		}
+
+		cfg, err := p.OAuth2Config(c.UserContext())
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Identity provider is unavailable",
+			})
+		}
+
+		state, err := randomString()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start sign-in",
+			})
+		}
+		nonce, err := randomString()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start sign-in",
+			})
+		}
+		verifier := oauth2.GenerateVerifier()
+
+		signed, err := middleware.SignClaims(stateClaims{
+			State:    state,
+			Nonce:    nonce,
+			Verifier: verifier,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Audience:  jwt.ClaimStrings{stateAudience},
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(stateTTL)),
+			},
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start sign-in",
+			})
+		}
+
+		c.Cookie(&fiber.Cookie{
+			Name:     stateCookie,
+			Value:    signed,
+			Path:     "/api/auth/oidc",
+			Expires:  time.Now().Add(stateTTL),
+			HTTPOnly: true,
+			Secure:   strings.HasPrefix(p.RedirectURL, "https://"),
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+
+		authURL := cfg.AuthCodeURL(state,
+			oauth2.S256ChallengeOption(verifier),
+			oauth2.SetAuthURLParam("nonce", nonce),
+		)
+		return c.Redirect(authURL, fiber.StatusFound)
+	}
+}
+
+// Callback finishes the flow: it exchanges the code, validates the ID token
+// and signs the user in, linking or creating their account as needed.
+func Callback(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p, err := GetProvider()
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "OIDC login is not configured",
+			})
+		}
+
+		if e := c.Query("error"); e != "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error":  "Identity provider refused the sign-in",
+				"detail": e,
+			})
+		}
+
+		// The state cookie is single use
+		cookie := c.Cookies(stateCookie)
+		c.ClearCookie(stateCookie)
+
+		st := &stateClaims{}
+		if cookie == "" || middleware.ParseClaims(cookie, st) != nil || st.State != c.Query("state") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Sign-in session expired or invalid, please start again",
+			})
+		}
+
+		ctx := c.UserContext()
+		cfg, err := p.OAuth2Config(ctx)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Identity provider is unavailable",
+			})
+		}
+
+		tok, err := cfg.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(st.Verifier))
+		if err != nil {
+			fmt.Println(err)
+			return failed(c, db)
+		}
+		rawIDToken, _ := tok.Extra("id_token").(string)
+		if rawIDToken == "" {
+			return failed(c, db)
+		}
+
+		claims, err := p.VerifyIDToken(ctx, rawIDToken, st.Nonce)
+		if err != nil {
+			fmt.Println(err)
+			return failed(c, db)
+		}
+
+		user, created, err := resolveUser(c, db, p.Issuer, claims)
+		if err != nil {
+			switch err {
+			case errUnverifiedEmail:
+				audit.RecordAuthEvent(db, c, types.AuthEvent{
+					Email:  claims.Email,
+					Type:   audit.EventSignIn,
+					Method: middleware.AuthMethodOIDC,
+					Reason: audit.ReasonUnverified,
+				})
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Your identity provider has not verified your email address",
+				})
+			case errDeactivated:
+				audit.RecordAuthEvent(db, c, types.AuthEvent{
+					UserID: user.ID,
+					Email:  user.Email,
+					Type:   audit.EventSignIn,
+					Method: middleware.AuthMethodOIDC,
+					Reason: audit.ReasonDeactivated,
+				})
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Account is deactivated",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to sign in",
+			})
+		}
+
+		t, orgID, err := users.IssueToken(db, user.ID, user.Email, middleware.AuthMethodOIDC)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to sign in",
+			})
+		}
+
+		if created {
+			audit.RecordAuthEvent(db, c, types.AuthEvent{
+				UserID:  user.ID,
+				OrgID:   orgID,
+				Email:   user.Email,
+				Type:    audit.EventSignUp,
+				Method:  middleware.AuthMethodOIDC,
+				Success: true,
+			})
+		}
+
+		audit.RecordAuthEvent(db, c, types.AuthEvent{
+			UserID:  user.ID,
+			OrgID:   orgID,
+			Email:   user.Email,
+			Type:    audit.EventSignIn,
+			Method:  middleware.AuthMethodOIDC,
+			Success: true,
+		})
+
+		// Browser flows hand the token to the frontend in the fragment so it
+		// never reaches server logs
+		if next := os.Getenv("OIDC_SUCCESS_URL"); next != "" {
+			fragment := url.Values{}
+			fragment.Set("token", t)
+			fragment.Set("org_id", strconv.Itoa(orgID))
+			return c.Redirect(next+"#"+fragment.Encode(), fiber.StatusFound)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"user_id": user.ID,
+			"org_id":  orgID,
+			"token":   t,
+			"created": created,
+			"message": "User signed in successfully",
+		})
+	}
+}
+
+// resolveUser finds the account for an identity provider login. Known
+// identities map straight to their user. Otherwise an account with the same
+// email is linked, or a new one is provisioned with a personal workspace.
+func resolveUser(c *fiber.Ctx, db *sql.DB, issuer string, claims *IDTokenClaims) (types.User, bool, error) {
+	userID, err := GetIdentityUserFromStore(db, issuer, claims.Subject)
+	if err != nil && err != sql.ErrNoRows {
+		return types.User{}, false, err
+	}
+
+	created := false
+	if err == sql.ErrNoRows {
+		if claims.Email == "" || !bool(claims.EmailVerified) || users.IsReservedEmail(claims.Email) {
+			return types.User{}, false, errUnverifiedEmail
+		}
+
+		var existing types.User
+		reset := false
+		userID, _, err = findUserByEmail(db, claims.Email)
+		switch err {
+		case nil:
+			existing, reset, err = prepareLink(db, userID)
+		case sql.ErrNoRows:
+			userID, err = provisionUser(db, claims)
+			created = true
+		}
+		if err != nil {
+			return types.User{}, false, err
+		}
+
+		if err := LinkIdentityInStore(db, issuer, claims.Subject, userID, claims.Email); err != nil {
+			return types.User{}, false, err
+		}
+		if !created {
+			recordLink(c, db, existing, reset)
+		}
+	}
+
+	user, err := users.GetUserFromStore(db, userID)
+	if err != nil {
+		return types.User{}, false, err
+	}
+	if user.DeactivatedAt != "" {
+		return user, false, errDeactivated
+	}
+	return user, created, nil
+}
+
+// prepareLink gets a local account with the same email ready to be linked to
+// an identity. Anyone could have signed up with an email they don't own, so
+// an account whose email was never verified and whose password was never
+// used to sign in has its password and existing sessions locked out first.
+// It reports whether that happened.
+func prepareLink(db *sql.DB, userID int) (types.User, bool, error) {
+	user, err := users.GetUserFromStore(db, userID)
+	if err != nil || user.EmailVerified {
+		return user, false, err
+	}
+	signedIn, err := audit.HasPasswordSignInInStore(db, userID)
+	if err != nil || signedIn {
+		return user, false, err
+	}
+
+	secret, err := randomString()
+	if err != nil {
+		return user, false, err
+	}
+	hashed, err := users.HashPassword(secret)
+	if err != nil {
+		return user, false, err
+	}
+	if err := users.ClaimAccountInStore(db, userID, hashed); err != nil {
+		return user, false, err
+	}
+	return user, true, nil
+}
+
+// recordLink audits an identity being linked to an existing account and
+// tells its owner, who may not have been the one signing in.
+func recordLink(c *fiber.Ctx, db *sql.DB, user types.User, reset bool) {
+	event := types.AuthEvent{
+		UserID:  user.ID,
+		Email:   user.Email,
+		Type:    audit.EventLinkIdentity,
+		Method:  middleware.AuthMethodOIDC,
+		Success: true,
+	}
+	body := "Your identity provider account was linked to your Zocket account and can now be used to sign in."
+	if reset {
+		event.Reason = audit.ReasonPasswordReset
+		body += " Because your email address had not been verified, your password was reset and every session signed out. Sign in with your identity provider from now on."
+	}
+	audit.RecordAuthEvent(db, c, event)
+
+	err := notifier.GetNotifier().Notify(context.Background(), notifier.Message{
+		Kind:    "identity_linked",
+		To:      user.Email,
+		Subject: "A sign-in method was added to your Zocket account",
+		Body:    body,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// provisionUser creates an account for a first-time identity provider login.
+// It gets a random password nobody knows, so the identity provider stays the
+// only way in.
+func provisionUser(db *sql.DB, claims *IDTokenClaims) (int, error) {
+	secret, err := randomString()
+	if err != nil {
+		return 0, err
+	}
+	hashed, err := users.HashPassword(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" {
+		if parts := strings.Fields(claims.Name); len(parts) > 0 {
+			firstName = parts[0]
+			if lastName == "" {
+				lastName = strings.Join(parts[1:], " ")
+			}
+		}
+	}
+	if firstName == "" {
+		firstName = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	userID, err := users.CreateUserInStore(db, types.User{
+		Email:         strings.ToLower(claims.Email),
+		Password:      hashed,
+		FirstName:     truncate(firstName, maxNameLength),
+		LastName:      truncate(lastName, maxNameLength),
+		EmailVerified: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := org.CreatePersonalOrgInStore(db, userID, truncate(firstName, maxNameLength)); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// failed records an OIDC sign-in that failed before we knew who it was for
+// and answers with a generic error.
+func failed(c *fiber.Ctx, db *sql.DB) error {
+	audit.RecordAuthEvent(db, c, types.AuthEvent{
+		Type:   audit.EventSignIn,
+		Method: middleware.AuthMethodOIDC,
+		Reason: audit.ReasonOIDCFailed,
+	})
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": "Sign-in with the identity provider failed",
+	})
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package oidc
+
+import (
+	"database/sql"
+)
+
+// GetIdentityUserFromStore returns the user linked to an identity provider
+// account, or sql.ErrNoRows when it hasn't been linked yet.
+func GetIdentityUserFromStore(db *sql.DB, issuer, subject string) (int, error) {
+	var userID int
+	query := `SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`
+	err := db.QueryRow(query, issuer, subject).Scan(&userID)
+	return userID, err
+}
+
+// LinkIdentityInStore links an identity provider account to a user.
+func LinkIdentityInStore(db *sql.DB, issuer, subject string, userID int, email string) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`
+	_, err := db.Exec(query, issuer, subject, userID, email)
+	return err
+}
+
+// findUserByEmail looks a user up by email regardless of case.
+func findUserByEmail(db *sql.DB, email string) (userID int, deactivated bool, err error) {
+	query := `
+		SELECT user_id, deactivated_at IS NOT NULL
+		FROM users WHERE LOWER(email) = LOWER($1)
+		ORDER BY user_id
+		LIMIT 1
+	`
+	err = db.QueryRow(query, email).Scan(&userID, &deactivated)
+	return userID, deactivated, err
+}
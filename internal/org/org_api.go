@@ -0,0 +1,327 @@
+package org
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/adarsh-jaiss/zocket/internal/audit"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// OrgHeader lets a client pick the active organization per request instead
+// of the one carried in its token.
+const OrgHeader = "X-Org-ID"
+
+// CreateOrgRequest represents the request body for creating an organization
+type CreateOrgRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// ResolveActiveOrg sets the principal's active organization and its role in
+// it. The X-Org-ID header wins over the org in the token; if neither names an
+// organization the user still belongs to, their first organization is used.
+// Tokens issued before the user's sessions were revoked are refused.
+func ResolveActiveOrg(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		revoked, err := isTokenRevoked(db, principal.UserID, principal.IssuedAt)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to resolve organization",
+			})
+		}
+		if revoked {
+			return middleware.Unauthorized(c)
+		}
+
+		if header := c.Get(OrgHeader); header != "" {
+			orgID, err := strconv.Atoi(header)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid organization ID",
+				})
+			}
+
+			role, err := authz.GetUserRoleFromStore(db, orgID, principal.UserID)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+						"error": "Not a member of this organization",
+					})
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to resolve organization",
+				})
+			}
+
+			principal.OrgID = orgID
+			principal.Roles = []string{role}
+			middleware.SetPrincipal(c, principal)
+			return c.Next()
+		}
+
+		if principal.OrgID != 0 {
+			role, err := authz.GetUserRoleFromStore(db, principal.OrgID, principal.UserID)
+			if err == nil {
+				principal.Roles = []string{role}
+				middleware.SetPrincipal(c, principal)
+				return c.Next()
+			}
+			if err != sql.ErrNoRows {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to resolve organization",
+				})
+			}
+		}
+
+		orgID, role, err := GetDefaultOrgForUser(db, principal.UserID)
+		if err != nil && err != sql.ErrNoRows {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to resolve organization",
+			})
+		}
+
+		// Tokens issued before a deactivation stop working here
+		if err == sql.ErrNoRows {
+			active, err := isUserActive(db, principal.UserID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to resolve organization",
+				})
+			}
+			if !active {
+				return middleware.Unauthorized(c)
+			}
+		}
+
+		// Users without any membership can still create an organization
+		principal.OrgID = orgID
+		principal.Roles = nil
+		if role != "" {
+			principal.Roles = []string{role}
+		}
+		middleware.SetPrincipal(c, principal)
+		return c.Next()
+	}
+}
+
+// CreateOrg creates an organization with the caller as its admin
+func CreateOrg(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req CreateOrgRequest
+		if err := c.BodyParser(&req); err != nil || req.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionCreate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to create organizations",
+			})
+		}
+
+		org := types.Organization{
+			Name:      req.Name,
+			CreatedBy: principal.UserID,
+		}
+		orgID, err := CreateOrgInStore(db, org)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create organization",
+			})
+		}
+
+		org.OrgID = orgID
+		org.Role = authz.RoleAdmin
+		return c.Status(fiber.StatusCreated).JSON(org)
+	}
+}
+
+// ListOrgs returns the organizations the caller belongs to
+func ListOrgs(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		orgs, err := ListOrgsForUser(db, principal.UserID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organizations",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(orgs)
+	}
+}
+
+// GetCurrentOrg returns the caller's active organization
+func GetCurrentOrg(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if principal.OrgID == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No active organization",
+			})
+		}
+
+		org, err := GetOrgFromStore(db, principal.OrgID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve organization",
+			})
+		}
+
+		if len(principal.Roles) > 0 {
+			org.Role = principal.Roles[0]
+		}
+		return c.Status(fiber.StatusOK).JSON(org)
+	}
+}
+
+// SwitchOrg issues a new token whose active organization is the :id param
+func SwitchOrg(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid organization ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		role, err := authz.GetUserRoleFromStore(db, orgID, principal.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Not a member of this organization",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to switch organization",
+			})
+		}
+
+		principal.OrgID = orgID
+		principal.Roles = []string{role}
+		t, err := middleware.GenerateToken(principal)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate token",
+			})
+		}
+
+		audit.RecordAuthEvent(db, c, types.AuthEvent{
+			UserID:  principal.UserID,
+			OrgID:   orgID,
+			Email:   principal.Email,
+			Type:    audit.EventSwitchOrg,
+			Method:  principal.AuthMethod,
+			Success: true,
+		})
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"org_id": orgID,
+			"role":   role,
+			"token":  t,
+		})
+	}
+}
+
+// ListMembers returns the members of the active organization
+func ListMembers(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionRead, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied",
+			})
+		}
+
+		members, err := ListMembersFromStore(db, principal.OrgID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve members",
+			})
+		}
+
+		// Only admins see member emails
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			for i := range members {
+				members[i].Email = ""
+			}
+		}
+
+		return c.Status(fiber.StatusOK).JSON(members)
+	}
+}
+
+// RemoveMember removes the user in the :userId param from the active
+// organization. Admin only.
+func RemoveMember(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("userId")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to manage members",
+			})
+		}
+		if userID == principal.UserID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Admins cannot remove themselves",
+			})
+		}
+
+		if err := RemoveMemberFromStore(db, principal.OrgID, userID); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Member not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to remove member",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Member removed successfully",
+		})
+	}
+}
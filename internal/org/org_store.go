@@ -0,0 +1,197 @@
+package org
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+// CreateOrgInStore creates an organization and makes its creator an admin.
+func CreateOrgInStore(db *sql.DB, org types.Organization) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (name, created_by, created_at)
+		VALUES ($1, $2, NOW())
+		RETURNING org_id
+	`
+	var orgID int
+	if err := tx.QueryRow(query, org.Name, org.CreatedBy).Scan(&orgID); err != nil {
+		return 0, err
+	}
+
+	query = `
+		INSERT INTO org_members (org_id, user_id, role, joined_at)
+		VALUES ($1, $2, $3, NOW())
+	`
+	if _, err := tx.Exec(query, orgID, org.CreatedBy, authz.RoleAdmin); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return orgID, nil
+}
+
+// CreatePersonalOrgInStore creates the workspace every new user starts with.
+func CreatePersonalOrgInStore(db *sql.DB, userID int, firstName string) (int, error) {
+	return CreateOrgInStore(db, types.Organization{
+		Name:      firstName + "'s workspace",
+		CreatedBy: userID,
+	})
+}
+
+func GetOrgFromStore(db *sql.DB, orgID int) (types.Organization, error) {
+	var org types.Organization
+	query := `
+		SELECT org_id, name, created_by, created_at
+		FROM organizations WHERE org_id = $1
+	`
+	err := db.QueryRow(query, orgID).Scan(
+		&org.OrgID,
+		&org.Name,
+		&org.CreatedBy,
+		&org.CreatedAt,
+	)
+	if err != nil {
+		return types.Organization{}, err
+	}
+	return org, nil
+}
+
+// ListOrgsForUser returns every organization the user belongs to along with
+// the user's role in it. Organizations that suspended the user are left out.
+func ListOrgsForUser(db *sql.DB, userID int) ([]types.Organization, error) {
+	query := `
+		SELECT o.org_id, o.name, o.created_by, o.created_at, m.role
+		FROM organizations o
+		JOIN org_members m ON m.org_id = o.org_id
+		WHERE m.user_id = $1 AND m.suspended_at IS NULL
+		ORDER BY m.joined_at
+	`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []types.Organization
+	for rows.Next() {
+		var org types.Organization
+		err := rows.Scan(
+			&org.OrgID,
+			&org.Name,
+			&org.CreatedBy,
+			&org.CreatedAt,
+			&org.Role,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, nil
+}
+
+// GetDefaultOrgForUser returns the organization the user joined first, or
+// sql.ErrNoRows when the user has no active memberships or is deactivated.
+func GetDefaultOrgForUser(db *sql.DB, userID int) (int, string, error) {
+	var orgID int
+	var role string
+	query := `
+		SELECT m.org_id, m.role
+		FROM org_members m
+		JOIN users u ON u.user_id = m.user_id AND u.deactivated_at IS NULL
+		WHERE m.user_id = $1 AND m.suspended_at IS NULL
+		ORDER BY m.joined_at, m.org_id
+		LIMIT 1
+	`
+	err := db.QueryRow(query, userID).Scan(&orgID, &role)
+	if err != nil {
+		return 0, "", err
+	}
+	return orgID, role, nil
+}
+
+func RemoveMemberFromStore(db *sql.DB, orgID, userID int) error {
+	query := `DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`
+	result, err := db.Exec(query, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func ListMembersFromStore(db *sql.DB, orgID int) ([]types.OrgMember, error) {
+	query := `
+		SELECT m.org_id, u.user_id, u.email, u.first_name, u.last_name, m.role, m.joined_at,
+			m.suspended_at IS NOT NULL
+		FROM org_members m
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY m.joined_at
+	`
+	rows, err := db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []types.OrgMember
+	for rows.Next() {
+		var member types.OrgMember
+		err := rows.Scan(
+			&member.OrgID,
+			&member.UserID,
+			&member.Email,
+			&member.FirstName,
+			&member.LastName,
+			&member.Role,
+			&member.JoinedAt,
+			&member.Suspended,
+		)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// isUserActive reports whether the user exists and has not been deactivated.
+func isUserActive(db *sql.DB, userID int) (bool, error) {
+	var active bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND deactivated_at IS NULL)`
+	err := db.QueryRow(query, userID).Scan(&active)
+	return active, err
+}
+
+// isTokenRevoked reports whether a token issued at issuedAt predates the
+// revocation of the user's sessions. Tokens carry whole seconds, so one
+// issued in the second of the revocation still counts.
+func isTokenRevoked(db *sql.DB, userID int, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM users
+			WHERE user_id = $1 AND sessions_revoked_at > to_timestamp($2)::timestamp
+		)
+	`
+	err := db.QueryRow(query, userID, issuedAt.Unix()).Scan(&revoked)
+	return revoked, err
+}
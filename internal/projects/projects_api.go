@@ -0,0 +1,258 @@
+package projects
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// keyPattern matches project keys such as ZKT, used to build task keys like ZKT-123
+var keyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+
+// UpdateProjectRequest represents the request body for updating a project.
+// Omitted fields are left unchanged.
+type UpdateProjectRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	OwnerID     *int    `json:"owner_id"`
+	Archived    *bool   `json:"archived"`
+}
+
+func CreateProject(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var project types.Project
+		if err := c.BodyParser(&project); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		project.Key = strings.ToUpper(strings.TrimSpace(project.Key))
+		if project.Name == "" || !keyPattern.MatchString(project.Key) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Project needs a name and a key of 2-10 letters or digits starting with a letter",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionCreate, authz.Resource{Kind: authz.ResourceProject}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to create projects",
+			})
+		}
+
+		project.OrgID = principal.OrgID
+		project.OwnerID = principal.UserID
+		project.Archived = false
+
+		project, err = CreateProjectInStore(db, project)
+		if err != nil {
+			if err == ErrKeyExists {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Project key already exists",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create project",
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(project)
+	}
+}
+
+func GetProject(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid project ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		project, err := GetProjectFromStore(db, principal.OrgID, projectID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Project not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve project",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionRead, authz.ProjectResource(project)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view this project",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(project)
+	}
+}
+
+// ListProjects returns the active organization's projects. Pass
+// ?archived=true to include archived ones.
+func ListProjects(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionList, authz.Resource{Kind: authz.ResourceProject}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to list projects",
+			})
+		}
+
+		projects, err := ListProjectsFromStore(db, principal.OrgID, c.QueryBool("archived"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve projects",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(projects)
+	}
+}
+
+func UpdateProject(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid project ID",
+			})
+		}
+
+		var req UpdateProjectRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		project, err := GetProjectFromStore(db, principal.OrgID, projectID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Project not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve project",
+			})
+		}
+
+		// Admins can update any project, members only the ones they own
+		if !authz.Can(principal, authz.ActionUpdate, authz.ProjectResource(project)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to update this project",
+			})
+		}
+
+		if req.Name != nil && *req.Name != "" {
+			project.Name = *req.Name
+		}
+		if req.Description != nil {
+			project.Description = *req.Description
+		}
+		if req.Archived != nil {
+			project.Archived = *req.Archived
+		}
+		if req.OwnerID != nil && *req.OwnerID != project.OwnerID {
+			if _, err := authz.GetUserRoleFromStore(db, principal.OrgID, *req.OwnerID); err != nil {
+				if err != sql.ErrNoRows {
+					fmt.Println(err)
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+						"error": "Failed to check owner",
+					})
+				}
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Owner is not a member of this organization",
+				})
+			}
+			project.OwnerID = *req.OwnerID
+		}
+
+		project, err = UpdateProjectInStore(db, project)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update project",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(project)
+	}
+}
+
+func DeleteProject(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid project ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		project, err := GetProjectFromStore(db, principal.OrgID, projectID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Project not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve project",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionDelete, authz.ProjectResource(project)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to delete this project",
+			})
+		}
+
+		if err := DeleteProjectFromStore(db, principal.OrgID, projectID); err != nil {
+			if err == ErrProjectHasTasks {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Project still has tasks, archive it instead",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete project",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Project deleted successfully",
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package projects
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/adarsh-jaiss/zocket/internal/websocket"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrKeyExists       = errors.New("project key already exists")
+	ErrProjectHasTasks = errors.New("project still has tasks")
+)
+
+func CreateProjectInStore(db *sql.DB, project types.Project) (types.Project, error) {
+	query := `
+		INSERT INTO projects (org_id, name, key, description, owner_id, archived, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, FALSE, NOW(), NOW())
+		RETURNING project_id, created_at, updated_at
+	`
+	err := db.QueryRow(
+		query,
+		project.OrgID,
+		project.Name,
+		project.Key,
+		project.Description,
+		project.OwnerID,
+	).Scan(&project.ProjectID, &project.CreatedAt, &project.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return types.Project{}, ErrKeyExists
+		}
+		return types.Project{}, err
+	}
+
+	broadcastProject(project.OrgID, "project_created", project)
+	return project, nil
+}
+
+func GetProjectFromStore(db *sql.DB, orgID, projectID int) (types.Project, error) {
+	var project types.Project
+	query := `
+		SELECT project_id, org_id, name, key, description, owner_id, archived, created_at, updated_at
+		FROM projects WHERE project_id = $1 AND org_id = $2
+	`
+	err := db.QueryRow(query, projectID, orgID).Scan(
+		&project.ProjectID,
+		&project.OrgID,
+		&project.Name,
+		&project.Key,
+		&project.Description,
+		&project.OwnerID,
+		&project.Archived,
+		&project.CreatedAt,
+		&project.UpdatedAt,
+	)
+	if err != nil {
+		return types.Project{}, err
+	}
+	return project, nil
+}
+
+// ListProjectsFromStore returns the projects of an organization, leaving out
+// archived ones unless includeArchived is set.
+func ListProjectsFromStore(db *sql.DB, orgID int, includeArchived bool) ([]types.Project, error) {
+	query := `
+		SELECT project_id, org_id, name, key, description, owner_id, archived, created_at, updated_at
+		FROM projects
+		WHERE org_id = $1 AND ($2 OR NOT archived)
+		ORDER BY name
+	`
+	rows, err := db.Query(query, orgID, includeArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []types.Project
+	for rows.Next() {
+		var project types.Project
+		err := rows.Scan(
+			&project.ProjectID,
+			&project.OrgID,
+			&project.Name,
+			&project.Key,
+			&project.Description,
+			&project.OwnerID,
+			&project.Archived,
+			&project.CreatedAt,
+			&project.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// UpdateProjectInStore saves name, description, owner and archived flag. The
+// key is immutable because task keys are derived from it.
+func UpdateProjectInStore(db *sql.DB, project types.Project) (types.Project, error) {
+	query := `
+		UPDATE projects
+		SET
+			name = $1,
+			description = $2,
+			owner_id = $3,
+			archived = $4,
+			updated_at = NOW()
+		WHERE project_id = $5 AND org_id = $6
+		RETURNING updated_at
+	`
+	err := db.QueryRow(
+		query,
+		project.Name,
+		project.Description,
+		project.OwnerID,
+		project.Archived,
+		project.ProjectID,
+		project.OrgID,
+	).Scan(&project.UpdatedAt)
+	if err != nil {
+		return types.Project{}, err
+	}
+
+	broadcastProject(project.OrgID, "project_updated", project)
+	return project, nil
+}
+
+// DeleteProjectFromStore deletes an empty project. Projects that still have
+// tasks should be archived instead.
+func DeleteProjectFromStore(db *sql.DB, orgID, projectID int) error {
+	var hasTasks bool
+	query := `SELECT EXISTS (SELECT 1 FROM tasks WHERE project_id = $1)`
+	if err := db.QueryRow(query, projectID).Scan(&hasTasks); err != nil {
+		return err
+	}
+	if hasTasks {
+		return ErrProjectHasTasks
+	}
+
+	query = `DELETE FROM projects WHERE project_id = $1 AND org_id = $2`
+	result, err := db.Exec(query, projectID, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	broadcastProject(orgID, "project_deleted", projectID)
+	return nil
+}
+
+func broadcastProject(orgID int, eventType string, data interface{}) {
+	projectJSON, _ := json.Marshal(map[string]interface{}{
+		"type": eventType,
+		"data": data,
+	})
+	websocket.GetManager().BroadcastToOrg(orgID, projectJSON)
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
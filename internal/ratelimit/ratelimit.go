@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Route groups with their own budgets
+const (
+	GroupAuth  = "auth"
+	GroupWrite = "write"
+	GroupAI    = "ai"
+)
+
+// Policy is the budget of a route group. Requests are counted against both
+// the caller's principal (when signed in) and their IP; either running out
+// rejects the request. A zero Rate disables that bucket.
+type Policy struct {
+	PerPrincipal Rate
+	PerIP        Rate
+	// WritesOnly skips GET, HEAD and OPTIONS requests.
+	WritesOnly bool
+}
+
+// defaultPolicies can be overridden per group and bucket with
+// RATE_LIMIT_<GROUP>_USER and RATE_LIMIT_<GROUP>_IP, e.g.
+// RATE_LIMIT_AI_USER=20/1h. Use 0 to disable a bucket.
+var defaultPolicies = map[string]Policy{
+	// Sign-in, sign-up and SSO are unauthenticated, so only IPs count
+	GroupAuth: {
+		PerIP: Rate{Burst: 20, Per: time.Minute},
+	},
+	GroupWrite: {
+		PerPrincipal: Rate{Burst: 120, Per: time.Minute},
+		PerIP:        Rate{Burst: 600, Per: time.Minute},
+		WritesOnly:   true,
+	},
+	GroupAI: {
+		PerPrincipal: Rate{Burst: 20, Per: time.Hour},
+		PerIP:        Rate{Burst: 60, Per: time.Hour},
+	},
+}
+
+var store Store
+
+// InitStore picks where buckets live from RATE_LIMIT_STORE: "memory" (the
+// default) keeps them per process, "postgres" shares them between replicas.
+func InitStore(db *sql.DB) {
+	if strings.EqualFold(os.Getenv("RATE_LIMIT_STORE"), "postgres") {
+		store = NewPostgresStore(db)
+		return
+	}
+	store = NewMemoryStore()
+}
+
+func GetStore() Store {
+	return store
+}
+
+// PolicyFor returns the budget of a group with environment overrides applied.
+func PolicyFor(group string) Policy {
+	policy := defaultPolicies[group]
+	prefix := "RATE_LIMIT_" + strings.ToUpper(group)
+	if rate, ok := rateFromEnv(prefix + "_USER"); ok {
+		policy.PerPrincipal = rate
+	}
+	if rate, ok := rateFromEnv(prefix + "_IP"); ok {
+		policy.PerIP = rate
+	}
+	return policy
+}
+
+// rateFromEnv parses "<burst>/<duration>" such as "60/1m", or "0" to disable.
+func rateFromEnv(name string) (Rate, bool) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return Rate{}, false
+	}
+	if v == "0" {
+		return Rate{}, true
+	}
+
+	burst, per, found := strings.Cut(v, "/")
+	n, err := strconv.Atoi(burst)
+	d, derr := time.ParseDuration(per)
+	if !found || err != nil || derr != nil || n < 0 || d <= 0 {
+		fmt.Printf("ignoring invalid %s=%q, expected e.g. 60/1m\n", name, v)
+		return Rate{}, false
+	}
+	return Rate{Burst: n, Per: d}, true
+}
+
+// New returns a middleware enforcing the budget of group. It has to run
+// after JWTProtected for per-principal buckets to apply.
+func New(group string) fiber.Handler {
+	policy := PolicyFor(group)
+
+	return func(c *fiber.Ctx) error {
+		if policy.WritesOnly {
+			switch c.Method() {
+			case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+				return c.Next()
+			}
+		}
+
+		var results []Result
+		if policy.PerPrincipal.Burst > 0 {
+			if principal, err := middleware.GetPrincipal(c); err == nil {
+				key := fmt.Sprintf("%s:user:%d", group, principal.UserID)
+				if res, ok := take(c, key, policy.PerPrincipal); ok {
+					results = append(results, res)
+				}
+			}
+		}
+		if policy.PerIP.Burst > 0 {
+			key := fmt.Sprintf("%s:ip:%s", group, c.IP())
+			if res, ok := take(c, key, policy.PerIP); ok {
+				results = append(results, res)
+			}
+		}
+		if len(results) == 0 {
+			return c.Next()
+		}
+
+		// Report the bucket closest to running out
+		tightest := results[0]
+		for _, res := range results[1:] {
+			if (!res.Allowed && tightest.Allowed) ||
+				(res.Allowed == tightest.Allowed && res.Remaining < tightest.Remaining) {
+				tightest = res
+			}
+		}
+
+		c.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		c.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(int(tightest.Reset.Seconds())))
+
+		if !tightest.Allowed {
+			retryAfter := int(tightest.RetryAfter.Seconds())
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "Too many requests, slow down",
+				"retry_after": retryAfter,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// When returns a middleware enforcing the budget of group only on requests
+// for which apply reports true, for routes that reach the AI model on
+// request.
+func When(apply func(c *fiber.Ctx) bool, group string) fiber.Handler {
+	limit := New(group)
+	return func(c *fiber.Ctx) error {
+		if !apply(c) {
+			return c.Next()
+		}
+		return limit(c)
+	}
+}
+
+// take counts the request against one bucket. When the store fails the
+// request is let through rather than taking the API down with it.
+func take(c *fiber.Ctx, key string, rate Rate) (Result, bool) {
+	res, err := store.Take(c.UserContext(), key, rate)
+	if err != nil {
+		fmt.Println(err)
+		return Result{}, false
+	}
+	return res, true
+}
@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket budget: up to Burst requests at once, refilled
+// evenly so that Burst requests are available again after Per.
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+// perSecond is how many tokens the bucket regains per second.
+func (r Rate) perSecond() float64 {
+	return float64(r.Burst) / r.Per.Seconds()
+}
+
+// Result describes a bucket after a request has been counted against it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+func newResult(rate Rate, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     rate.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(rate.Burst) - tokens) / rate.perSecond()),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate.perSecond())
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// Store keeps token buckets. Take removes one token from the bucket at key
+// if there is one.
+type Store interface {
+	Take(ctx context.Context, key string, rate Rate) (Result, error)
+}
+
+// MemoryStore keeps buckets in process. Each replica counts on its own, so
+// it suits single instance deployments and development.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate Rate) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.updated).Seconds()*rate.perSecond())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(rate, b.tokens, allowed), nil
+}
+
+// sweep drops buckets nobody touched for a while; an idle bucket is full
+// anyway. Callers hold s.mu.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > idleBucketTTL {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+const (
+	sweepInterval = 10 * time.Minute
+	idleBucketTTL = 24 * time.Hour
+)
+
+// PostgresStore keeps buckets in the rate_limits table so that every
+// replica shares the same budget.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, lastSweep: time.Now()}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, rate Rate) (Result, error) {
+	s.maybeSweep()
+
+	// Refill, then take a token if there is one, in a single statement so
+	// concurrent requests can't both spend the last token.
+	query := `
+		INSERT INTO rate_limits AS r (key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, TRUE, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE
+				WHEN LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at)::float8 * $3::float8) >= 1
+				THEN LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at)::float8 * $3::float8) - 1
+				ELSE LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at)::float8 * $3::float8)
+			END,
+			allowed = LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at)::float8 * $3::float8) >= 1,
+			updated_at = NOW()
+		RETURNING tokens, allowed
+	`
+	var tokens float64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, query, key, rate.Burst, rate.perSecond()).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(rate, tokens, allowed), nil
+}
+
+// maybeSweep deletes idle buckets in the background now and then.
+func (s *PostgresStore) maybeSweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = time.Now()
+
+	go func() {
+		query := `DELETE FROM rate_limits WHERE updated_at < NOW() - $1 * INTERVAL '1 second'`
+		if _, err := s.db.Exec(query, int(idleBucketTTL.Seconds())); err != nil {
+			fmt.Println(err)
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const (
+	maxOpenTasks      = 50
+	maxCompletedTasks = 200
+	maxSimilarTasks   = 10
+	completedWindow   = 90 * 24 * time.Hour
+)
+
+// buildTaskContext gathers what the analyzer should know about work around
+// task. Lists are ordered by relevance so the prompt keeps the most useful
+// items when the token budget runs out. Lookups that fail are logged and
+// left out rather than failing the analysis.
+func buildTaskContext(db *sql.DB, task types.Task, notes string) ai.TaskContext {
+	tc := ai.TaskContext{Notes: notes}
+
+	suggestions, err := GetTaskSuggestions(db, task.TaskID)
+	if err != nil {
+		fmt.Println(err)
+	}
+	tc.ExistingSubTasks = existingSubTasks(suggestions)
+
+	words := taskWords(task)
+
+	open, err := ListOpenTasksFromStore(db, task, maxOpenTasks)
+	if err != nil {
+		fmt.Println(err)
+	}
+	tc.OpenTasks = rankBySimilarity(words, open, false)
+
+	completed, err := ListCompletedTasksFromStore(db, task, time.Now().Add(-completedWindow), maxCompletedTasks)
+	if err != nil {
+		fmt.Println(err)
+	}
+	tc.CompletedTasks = rankBySimilarity(words, completed, true)
+	if len(tc.CompletedTasks) > maxSimilarTasks {
+		tc.CompletedTasks = tc.CompletedTasks[:maxSimilarTasks]
+	}
+
+	return tc
+}
+
+// existingSubTasks flattens earlier suggestions into one list of subtasks,
+// accepted suggestions first, without repeating titles.
+func existingSubTasks(suggestions []types.TaskSuggestion) []types.Task {
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Accepted && !suggestions[j].Accepted
+	})
+
+	seen := map[string]bool{}
+	var subTasks []types.Task
+	for _, suggestion := range suggestions {
+		for _, subTask := range suggestion.SubTasks {
+			key := strings.ToLower(strings.TrimSpace(subTask.Title))
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			subTasks = append(subTasks, subTask)
+		}
+	}
+	return subTasks
+}
+
+// rankBySimilarity orders tasks by how many words they share with words,
+// keeping the existing order for ties. With similarOnly, tasks sharing no
+// words are dropped.
+func rankBySimilarity(words map[string]bool, tasks []types.Task, similarOnly bool) []types.Task {
+	scores := make(map[int]float64, len(tasks))
+	ranked := make([]types.Task, 0, len(tasks))
+	for _, t := range tasks {
+		score := jaccard(words, taskWords(t))
+		if similarOnly && score == 0 {
+			continue
+		}
+		scores[t.TaskID] = score
+		ranked = append(ranked, t)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i].TaskID] > scores[ranked[j].TaskID]
+	})
+	return ranked
+}
+
+// stopWords are too common in task text to say anything about similarity.
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "from": true, "that": true,
+	"this": true, "into": true, "when": true, "should": true, "add": true, "task": true,
+}
+
+// taskWords is the set of distinct lowercase words of three or more
+// letters in the task's title and description.
+func taskWords(t types.Task) map[string]bool {
+	words := map[string]bool{}
+	fields := strings.FieldsFunc(strings.ToLower(t.Title+" "+t.Description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range fields {
+		if len(w) >= 3 && !stopWords[w] {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,187 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/jobs"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"golang.org/x/sync/singleflight"
+)
+
+// JobAnalyze is the job kind for AI task breakdowns.
+const JobAnalyze = "analyze_task"
+
+// defaultAnalysisCacheHours is used when AI_CACHE_TTL_HOURS is not set.
+const defaultAnalysisCacheHours = 24
+
+// analyzeGroup collapses concurrent identical analyses into one call.
+var analyzeGroup singleflight.Group
+
+// analyzePayload is what AnalyzeTask queues for the worker.
+type analyzePayload struct {
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context,omitempty"`
+	Stream      bool   `json:"stream,omitempty"`
+	Force       bool   `json:"force,omitempty"`
+}
+
+// RegisterJobs registers the task job handlers with the job queue.
+func RegisterJobs() {
+	jobs.Register(JobAnalyze, "analysis", runAnalyzeJob)
+}
+
+func runAnalyzeJob(ctx context.Context, db *sql.DB, job types.Job) (interface{}, error) {
+	var payload analyzePayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+
+	task, err := GetTaskFromStore(db, job.OrgID, job.TaskID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, jobs.Permanent(errors.New("task not found"))
+		}
+		return nil, err
+	}
+
+	prompt, err := ai.GetOrgPrompt(db, job.OrgID, ai.FeatureAnalyze)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identical analyses running at the same time on this instance share
+	// one model call
+	contentHash := analysisContentHash(task, payload)
+	key := fmt.Sprintf("%d/%s/%s/%s", task.TaskID, contentHash, prompt.Version, ai.DefaultModel)
+	if payload.Force {
+		key = "force/" + key
+	}
+	analysis, err, _ := analyzeGroup.Do(key, func() (interface{}, error) {
+		return analyzeTask(ctx, db, job, task, payload, prompt, contentHash)
+	})
+	return analysis, err
+}
+
+// analyzeTask returns the cached breakdown for the task's current content,
+// or asks the model and stores its suggestions.
+func analyzeTask(ctx context.Context, db *sql.DB, job types.Job, task types.Task, payload analyzePayload, prompt *ai.Prompt, contentHash string) (*types.AITaskBreakdownResponse, error) {
+	ttl := analysisCacheTTL()
+	if ttl > 0 && !payload.Force {
+		analysis, err := GetCachedAnalysisFromStore(db, task.TaskID, contentHash, prompt.Version, ai.DefaultModel)
+		if err == nil {
+			analysis.Cached = true
+			return analysis, nil
+		}
+		if err != sql.ErrNoRows {
+			fmt.Println(err)
+		}
+	}
+
+	// The quota may have run out while the job was queued
+	if err := ai.CheckQuotaInStore(db, job.OrgID, job.UserID); err != nil {
+		var quotaErr *ai.QuotaError
+		if errors.As(err, &quotaErr) {
+			return nil, jobs.Permanent(errors.New("monthly AI quota exceeded"))
+		}
+		return nil, err
+	}
+
+	tc := buildTaskContext(db, task, payload.Context)
+
+	// If additional description is provided, append it to task description
+	if payload.Description != "" {
+		task.Description += "\n\nAdditional Context:\n" + payload.Description
+	}
+
+	redactor, err := ai.GetOrgRedactor(db, job.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	gemini, err := ai.NewGeminiClient()
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	defer gemini.Close()
+
+	principal := middleware.Principal{UserID: job.UserID, OrgID: job.OrgID}
+	var analysis *types.AITaskBreakdownResponse
+	var usage ai.Usage
+	if payload.Stream {
+		analysis, usage, err = gemini.AnalyzeTaskStream(ctx, task, tc, prompt, redactor, func(text string) {
+			jobs.Notify(job, "analysis_chunk", map[string]interface{}{"text": text})
+		})
+	} else {
+		analysis, usage, err = gemini.AnalyzeTask(ctx, task, tc, prompt, redactor)
+	}
+	ai.RecordUsage(db, principal, task.TaskID, ai.FeatureAnalyze, usage, err == nil)
+	if err != nil {
+		// The model already had a chance to repair its answer, and the
+		// provider call was already retried if that could help
+		var open *ai.CircuitOpenError
+		switch {
+		case errors.As(err, &open):
+			return nil, jobs.RetryAt(err, open.RetryAt)
+		case ai.IsInvalidOutput(err), !ai.IsRetryable(err):
+			return nil, jobs.Permanent(err)
+		}
+		return nil, err
+	}
+
+	// Store suggestions in database
+	for i := range analysis.Suggestions {
+		suggestion := &analysis.Suggestions[i]
+		suggestion.TaskID = task.TaskID
+		suggestion.UserID = job.UserID
+		suggestion.PromptVersion = prompt.Version
+		if err := StoreSuggestion(db, task, *suggestion); err != nil {
+			// Log error but continue
+			fmt.Println(err)
+		}
+	}
+
+	if ttl > 0 {
+		if err := SaveAnalysisCacheInStore(db, task.TaskID, contentHash, prompt.Version, ai.DefaultModel, analysis, ttl); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return analysis, nil
+}
+
+// analysisContentHash identifies what the model is asked about: the task's
+// own fields and the extras of the request. Related tasks are left out, or
+// the suggestions stored by one analysis would change the key of the next.
+func analysisContentHash(task types.Task, payload analyzePayload) string {
+	content, _ := json.Marshal([]string{
+		task.Title,
+		task.Description,
+		string(task.Priority),
+		task.DueDate,
+		payload.Description,
+		payload.Context,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// analysisCacheTTL is how long a breakdown is reused for an unchanged task,
+// AI_CACHE_TTL_HOURS (default 24). 0 turns the cache off.
+func analysisCacheTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("AI_CACHE_TTL_HOURS"))
+	if err != nil || hours < 0 {
+		hours = defaultAnalysisCacheHours
+	}
+	return time.Duration(hours) * time.Hour
+}
@@ -0,0 +1,144 @@
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const (
+	estimateWindow        = 180 * 24 * time.Hour
+	maxEstimateSubTasks   = 20
+	maxEstimateExamples   = 10
+	minCalibrationSamples = 3
+	workdayHours          = 8
+)
+
+// estimateInputs gathers what an estimate of task is based on: the
+// subtasks suggested for it and the team's history. Either failing only
+// leaves it out, so the task is still estimated.
+func estimateInputs(db *sql.DB, task types.Task) ([]types.Task, ai.EstimateHistory, types.EstimateCalibration) {
+	suggestions, err := GetTaskSuggestions(db, task.TaskID)
+	if err != nil {
+		fmt.Println(err)
+	}
+	subTasks := existingSubTasks(suggestions)
+	if len(subTasks) > maxEstimateSubTasks {
+		subTasks = subTasks[:maxEstimateSubTasks]
+	}
+
+	history, calibration, err := estimateHistory(db, task)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return subTasks, history, calibration
+}
+
+// estimateHistory looks at how long the organization's tasks completed in
+// the last estimateWindow took, in working hours, to find examples similar
+// to task and the team's pace.
+func estimateHistory(db *sql.DB, task types.Task) (ai.EstimateHistory, types.EstimateCalibration, error) {
+	var history ai.EstimateHistory
+	var calibration types.EstimateCalibration
+
+	completed, err := ListCompletedTasksFromStore(db, task, time.Now().Add(-estimateWindow), maxCompletedTasks)
+	if err != nil || len(completed) == 0 {
+		return history, calibration, err
+	}
+	taskIDs := make([]int, len(completed))
+	for i, t := range completed {
+		taskIDs[i] = t.TaskID
+	}
+	cycles, err := ListTaskCyclesFromStore(db, taskIDs)
+	if err != nil {
+		return history, calibration, err
+	}
+
+	actual := map[int]float64{}
+	var timed []types.Task
+	var perPoint, overrun []float64
+	for _, t := range completed {
+		cycle, ok := cycles[t.TaskID]
+		if !ok {
+			continue
+		}
+		hours := workingHours(cycle.startedAt, cycle.doneAt)
+		if hours <= 0 {
+			continue
+		}
+		actual[t.TaskID] = hours
+		timed = append(timed, t)
+		if t.EstimatePoints != nil && *t.EstimatePoints > 0 {
+			perPoint = append(perPoint, hours / *t.EstimatePoints)
+		}
+		if t.EstimateHours != nil && *t.EstimateHours > 0 {
+			overrun = append(overrun, hours / *t.EstimateHours)
+		}
+	}
+
+	calibration.Samples = len(timed)
+	if len(perPoint) >= minCalibrationSamples {
+		calibration.HoursPerPoint = round(median(perPoint), 1)
+	}
+	if len(overrun) >= minCalibrationSamples {
+		calibration.OverrunRatio = round(median(overrun), 2)
+	}
+	history.HoursPerPoint = calibration.HoursPerPoint
+	history.OverrunRatio = calibration.OverrunRatio
+
+	similar := rankBySimilarity(taskWords(task), timed, true)
+	if len(similar) > maxEstimateExamples {
+		similar = similar[:maxEstimateExamples]
+	}
+	for _, t := range similar {
+		example := ai.EstimateExample{
+			Title:       t.Title,
+			ActualHours: round(actual[t.TaskID], 1),
+		}
+		if t.EstimatePoints != nil {
+			example.Points = *t.EstimatePoints
+		}
+		if t.EstimateHours != nil {
+			example.EstimateHours = *t.EstimateHours
+		}
+		history.Examples = append(history.Examples, example)
+	}
+	return history, calibration, nil
+}
+
+// workingHours counts the hours between start and end on weekdays, at most
+// workdayHours a day, so a task left overnight isn't counted as a day's work.
+func workingHours(start, end time.Time) float64 {
+	var hours float64
+	for day := start; day.Before(end); {
+		next := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location())
+		if next.After(end) {
+			next = end
+		}
+		if wd := day.Weekday(); wd != time.Saturday && wd != time.Sunday {
+			hours += math.Min(next.Sub(day).Hours(), workdayHours)
+		}
+		day = next
+	}
+	return hours
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
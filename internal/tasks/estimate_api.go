@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// EstimateTask proposes story points and hours for a task and the subtasks
+// suggested for it, calibrated against the team's completed tasks. With
+// ?apply=true the task's estimate is saved as well.
+func EstimateTask(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		taskID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid task ID",
+			})
+		}
+		apply := c.QueryBool("apply")
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, taskID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Task not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve task",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionAnalyze, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to analyze this task",
+			})
+		}
+		if apply && !authz.Can(principal, authz.ActionUpdate, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to update this task",
+			})
+		}
+
+		if ok, err := ai.CheckQuota(c, db, principal); !ok {
+			return err
+		}
+
+		subTasks, history, calibration := estimateInputs(db, task)
+
+		prompt, err := ai.GetOrgPrompt(db, principal.OrgID, ai.FeatureEstimate)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch AI prompt",
+			})
+		}
+
+		redactor, err := ai.GetOrgRedactor(db, principal.OrgID)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch redaction rules",
+			})
+		}
+
+		gemini, err := ai.NewGeminiClient()
+		if err != nil {
+			fmt.Println(err)
+			return ai.WriteError(c, err, "Failed to initialize AI service")
+		}
+		defer gemini.Close()
+
+		estimate, usage, err := gemini.EstimateEffort(c.UserContext(), task, subTasks, history, prompt, redactor)
+		ai.RecordUsage(db, principal, task.TaskID, ai.FeatureEstimate, usage, err == nil)
+		if err != nil {
+			fmt.Println(err)
+			return ai.WriteError(c, err, "Failed to estimate task")
+		}
+
+		resp := types.TaskEstimate{
+			TaskID:      task.TaskID,
+			Estimate:    estimate.Task,
+			SubTasks:    make([]types.SubTaskEstimate, len(subTasks)),
+			Calibration: calibration,
+		}
+		for i, s := range subTasks {
+			resp.SubTasks[i] = types.SubTaskEstimate{
+				Title:          s.Title,
+				Complexity:     s.Complexity,
+				EffortEstimate: estimate.SubTasks[i],
+			}
+		}
+
+		if apply {
+			// Only the estimate is written, so edits made while the model
+			// was thinking aren't undone
+			points, hours, confidence := estimate.Task.Points, estimate.Task.Hours, estimate.Task.Confidence
+			updated, err := SetTaskEstimateInStore(db, types.Task{
+				TaskID:             task.TaskID,
+				OrgID:              task.OrgID,
+				EstimatePoints:     &points,
+				EstimateHours:      &hours,
+				EstimateConfidence: &confidence,
+				EstimateSource:     types.EstimateAI,
+			})
+			if err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to save estimate",
+				})
+			}
+			resp.Task = &updated
+			resp.Applied = true
+		}
+
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
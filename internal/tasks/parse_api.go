@@ -0,0 +1,222 @@
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/projects"
+	users "github.com/adarsh-jaiss/zocket/internal/user"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxParseTextLength keeps free-text requests to a message's worth.
+const maxParseTextLength = 2000
+
+// ParseTask drafts a task from free text such as "Ask Priya to fix the
+// login bug by Friday, high priority". The draft is returned for preview,
+// or created right away when commit is set.
+func ParseTask(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req types.ParseTaskRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		req.Text = strings.TrimSpace(req.Text)
+		if req.Text == "" || len(req.Text) > maxParseTextLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Text is required and must be at most %d characters", maxParseTextLength),
+			})
+		}
+
+		loc := time.UTC
+		if req.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(req.Timezone); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Unknown timezone",
+				})
+			}
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionCreate, authz.Resource{Kind: authz.ResourceTask}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to create tasks",
+			})
+		}
+
+		// Checked up front so previews don't offer a task that can't be created
+		if req.ProjectID != 0 {
+			project, err := projects.GetProjectFromStore(db, principal.OrgID, req.ProjectID)
+			if err != nil && err != sql.ErrNoRows {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve project",
+				})
+			}
+			if err == sql.ErrNoRows || project.Archived {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Project not found or archived",
+				})
+			}
+		}
+
+		if ok, err := ai.CheckQuota(c, db, principal); !ok {
+			return err
+		}
+
+		redactor, err := ai.GetOrgRedactor(db, principal.OrgID)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch redaction rules",
+			})
+		}
+
+		gemini, err := ai.NewGeminiClient()
+		if err != nil {
+			fmt.Println(err)
+			return ai.WriteError(c, err, "Failed to initialize AI service")
+		}
+		defer gemini.Close()
+
+		draft, usage, err := gemini.ParseTask(c.UserContext(), req.Text, time.Now().In(loc), redactor)
+		ai.RecordUsage(db, principal, 0, ai.FeatureParseTask, usage, err == nil)
+		if err != nil {
+			fmt.Println(err)
+			if ai.IsInvalidOutput(err) {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+					"error": "Could not turn the text into a task",
+				})
+			}
+			return ai.WriteError(c, err, "Failed to parse task")
+		}
+
+		task := types.Task{
+			OrgID:       principal.OrgID,
+			ProjectID:   req.ProjectID,
+			Title:       draft.Title,
+			Description: draft.Description,
+			Priority:    draft.Priority,
+			Status:      types.ToDo,
+			DueDate:     draft.DueDate,
+			CreatedBy:   principal.UserID,
+		}
+		if task.Priority == "" {
+			task.Priority = types.Medium
+		}
+
+		var assignee *types.AssigneeMatch
+		if draft.Assignee != "" {
+			match, err := resolveAssignee(db, principal.OrgID, draft.Assignee)
+			if err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to resolve assignee",
+				})
+			}
+			task.AssignedTo = match.UserID
+			assignee = &match
+		}
+
+		if !req.Commit {
+			task.PossibleDuplicates = possibleDuplicates(c.UserContext(), db, principal, task)
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"task":     task,
+				"assignee": assignee,
+				"created":  false,
+			})
+		}
+
+		task, err = CreateTaskInStore(db, task)
+		if err != nil {
+			if err == ErrProjectUnavailable {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Project not found or archived",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create task",
+			})
+		}
+
+		task.PossibleDuplicates = possibleDuplicates(c.UserContext(), db, principal, task)
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"task":     task,
+			"assignee": assignee,
+			"created":  true,
+		})
+	}
+}
+
+// resolveAssignee matches the person named in parsed text against the
+// organization's active members by email, full name, first name, last name
+// or the start of a first name, in that order. The first rule that picks
+// out exactly one member wins; when a rule matches several, they are
+// returned as candidates and nobody is assigned.
+func resolveAssignee(db *sql.DB, orgID int, query string) (types.AssigneeMatch, error) {
+	match := types.AssigneeMatch{Query: query}
+	q := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(query), "@"))
+	if q == "" {
+		return match, nil
+	}
+
+	members, err := users.GetAllUsers(db, orgID)
+	if err != nil {
+		return match, err
+	}
+
+	// Deactivated members can't be assigned tasks
+	profiles, err := users.ListMembersPublicFromStore(db, orgID)
+	if err != nil {
+		return match, err
+	}
+	active := map[int]types.PublicUser{}
+	for _, p := range profiles {
+		if !p.Deactivated {
+			active[p.ID] = p
+		}
+	}
+
+	rules := []func(u types.User) bool{
+		func(u types.User) bool { return strings.ToLower(u.Email) == q },
+		func(u types.User) bool { return strings.ToLower(u.FirstName+" "+u.LastName) == q },
+		func(u types.User) bool { return strings.ToLower(u.FirstName) == q },
+		func(u types.User) bool { return strings.ToLower(u.LastName) == q },
+		func(u types.User) bool { return strings.HasPrefix(strings.ToLower(u.FirstName), q) },
+	}
+
+	for _, rule := range rules {
+		var found []types.User
+		for _, u := range members {
+			if _, ok := active[u.ID]; ok && rule(u) {
+				found = append(found, u)
+			}
+		}
+
+		switch {
+		case len(found) == 1:
+			match.UserID = found[0].ID
+			return match, nil
+		case len(found) > 1:
+			for _, u := range found {
+				match.Candidates = append(match.Candidates, active[u.ID])
+			}
+			return match, nil
+		}
+	}
+	return match, nil
+}
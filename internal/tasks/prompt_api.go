@@ -0,0 +1,113 @@
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PreviewPrompt renders a feature's prompt for a task exactly as it would be
+// sent to the model, sensitive values redacted, without calling it. Admin
+// only, since the prompt includes other tasks from the organization.
+func PreviewPrompt(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		feature := c.Params("feature")
+		var req types.PromptPreviewRequest
+		if err := c.BodyParser(&req); err != nil || req.TaskID == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to preview AI prompts",
+			})
+		}
+
+		// Only analysis and estimates are driven by templates so far
+		if feature != ai.FeatureAnalyze && feature != ai.FeatureEstimate {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unknown AI feature",
+			})
+		}
+
+		var prompt *ai.Prompt
+		if req.Version != "" {
+			prompt, err = ai.GetPrompt(feature, req.Version)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":    "Unknown prompt version",
+					"versions": ai.PromptVersions(feature),
+				})
+			}
+		} else {
+			prompt, err = ai.GetOrgPrompt(db, principal.OrgID, feature)
+			if err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to fetch AI prompt",
+				})
+			}
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, req.TaskID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Task not found",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve task",
+			})
+		}
+
+		var rendered string
+		if feature == ai.FeatureEstimate {
+			// Built the same way as in EstimateTask
+			subTasks, history, _ := estimateInputs(db, task)
+			rendered, err = ai.RenderEstimatePrompt(prompt, task, subTasks, history)
+		} else {
+			// Built the same way as in runAnalyzeJob
+			tc := buildTaskContext(db, task, req.Context)
+			if req.Description != "" {
+				task.Description += "\n\nAdditional Context:\n" + req.Description
+			}
+			rendered, err = ai.RenderAnalyzePrompt(prompt, task, tc)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to render AI prompt",
+			})
+		}
+
+		redactor, err := ai.GetOrgRedactor(db, principal.OrgID)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch redaction rules",
+			})
+		}
+		rendered, redaction := redactor.Redact(rendered)
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"feature":    feature,
+			"version":    prompt.Version,
+			"template":   prompt.Source(),
+			"prompt":     rendered,
+			"redactions": redaction.Counts,
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	users "github.com/adarsh-jaiss/zocket/internal/user"
+	"github.com/adarsh-jaiss/zocket/types"
+)
+
+const (
+	// maxHistoryTasks bounds the assignment history that is scored
+	maxHistoryTasks = 500
+	// maxCandidates bounds the members described to the model
+	maxCandidates = 10
+	// workloadPenalty is what each open task costs a member's score,
+	// against an affinity of up to 3 for three identical past tasks
+	workloadPenalty = 0.05
+	// dueSoon makes tasks due within this window High priority
+	dueSoon = 72 * time.Hour
+)
+
+// priorityWords mark task text as urgent or as something that can wait.
+var priorityWords = map[types.TaskPriority][]string{
+	types.High: {"urgent", "asap", "critical", "blocker", "blocking", "outage", "down", "broken", "crash", "security", "incident", "hotfix"},
+	types.Low:  {"minor", "typo", "cleanup", "someday", "eventually", "cosmetic", "refactor", "docs", "nice to have"},
+}
+
+// candidate is a member scored for a task.
+type candidate struct {
+	profile   types.PublicUser
+	openTasks int
+	affinity  float64
+	similar   []types.Task
+}
+
+func (c candidate) score() float64 {
+	return c.affinity - workloadPenalty*float64(c.openTasks)
+}
+
+// recommendForTask suggests a priority and assignee for task and stores
+// the suggestion, to be applied to fields only once accepted. The model is
+// used when it's configured and the quota allows; otherwise, or when the
+// model fails, the heuristic decides.
+func recommendForTask(ctx context.Context, db *sql.DB, principal middleware.Principal, task types.Task, fields []string) (types.TaskRecommendation, error) {
+	candidates, err := rankCandidates(db, task)
+	if err != nil {
+		return types.TaskRecommendation{}, err
+	}
+
+	rec := heuristicRecommendation(task, candidates)
+	if aiRec, ok := aiRecommendation(ctx, db, principal, task, candidates); ok {
+		rec = aiRec
+	}
+
+	rec.TaskID = task.TaskID
+	rec.UserID = principal.UserID
+	rec.Fields = fields
+	return CreateRecommendationInStore(db, rec)
+}
+
+// rankCandidates scores the organization's active members by how much of
+// their assignment history resembles task and how busy they are, best
+// first. Viewers can't work on tasks and are left out.
+func rankCandidates(db *sql.DB, task types.Task) ([]candidate, error) {
+	profiles, err := users.ListMembersPublicFromStore(db, task.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	openTasks, err := CountOpenTasksByAssigneeFromStore(db, task.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := ListAssignedTasksFromStore(db, task.OrgID, maxHistoryTasks)
+	if err != nil {
+		return nil, err
+	}
+
+	words := taskWords(task)
+	similar := map[int][]types.Task{}
+	scores := map[int]float64{}
+	for _, t := range history {
+		if t.TaskID == task.TaskID {
+			continue
+		}
+		if score := jaccard(words, taskWords(t)); score > 0 {
+			similar[t.AssignedTo] = append(similar[t.AssignedTo], t)
+			scores[t.TaskID] = score
+		}
+	}
+
+	var candidates []candidate
+	for _, p := range profiles {
+		if p.Deactivated || p.Role == authz.RoleViewer {
+			continue
+		}
+		c := candidate{profile: p, openTasks: openTasks[p.ID], similar: similar[p.ID]}
+		sort.SliceStable(c.similar, func(i, j int) bool {
+			return scores[c.similar[i].TaskID] > scores[c.similar[j].TaskID]
+		})
+		if len(c.similar) > 3 {
+			c.similar = c.similar[:3]
+		}
+		for _, t := range c.similar {
+			c.affinity += scores[t.TaskID]
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score() != candidates[j].score() {
+			return candidates[i].score() > candidates[j].score()
+		}
+		return candidates[i].openTasks < candidates[j].openTasks
+	})
+	return candidates, nil
+}
+
+// heuristicRecommendation picks the best-ranked candidate and a priority
+// from the task's wording, its due date or, failing those, the priority
+// most common among similar past tasks.
+func heuristicRecommendation(task types.Task, candidates []candidate) types.TaskRecommendation {
+	rec := types.TaskRecommendation{Source: types.RecommendationHeuristic}
+	var reasons []string
+
+	rec.Priority, reasons = heuristicPriority(task, candidates)
+
+	if len(candidates) > 0 {
+		best := candidates[0]
+		rec.AssigneeID = best.profile.ID
+		name := strings.TrimSpace(best.profile.FirstName + " " + best.profile.LastName)
+		if len(best.similar) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s worked on similar tasks such as %q and has %d open tasks.", name, best.similar[0].Title, best.openTasks))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("%s has the lightest workload with %d open tasks.", name, best.openTasks))
+		}
+	} else {
+		reasons = append(reasons, "No active member can be assigned.")
+	}
+
+	rec.Rationale = strings.Join(reasons, " ")
+	return rec
+}
+
+func heuristicPriority(task types.Task, candidates []candidate) (types.TaskPriority, []string) {
+	text := strings.ToLower(task.Title + " " + task.Description)
+	for _, p := range []types.TaskPriority{types.High, types.Low} {
+		for _, w := range priorityWords[p] {
+			if containsWord(text, w) {
+				return p, []string{fmt.Sprintf("Priority %s because the task mentions %q.", p, w)}
+			}
+		}
+	}
+
+	if task.DueDate != "" {
+		if due, err := time.Parse(types.DueDateLayout, task.DueDate); err == nil && time.Until(due) < dueSoon {
+			return types.High, []string{fmt.Sprintf("Priority High because the task is due %s.", task.DueDate)}
+		}
+	}
+
+	counts := map[types.TaskPriority]int{}
+	for _, c := range candidates {
+		for _, t := range c.similar {
+			counts[t.Priority]++
+		}
+	}
+	best, bestCount := types.Medium, 0
+	for _, p := range []types.TaskPriority{types.Medium, types.High, types.Low} {
+		if counts[p] > bestCount {
+			best, bestCount = p, counts[p]
+		}
+	}
+	if bestCount > 0 {
+		return best, []string{fmt.Sprintf("Priority %s like most similar past tasks.", best)}
+	}
+	return types.Medium, []string{"Priority Medium as nothing suggests otherwise."}
+}
+
+// containsWord reports whether phrase appears in text on word boundaries.
+func containsWord(text, phrase string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], phrase)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(phrase)
+		before := start == 0 || !isWordByte(text[start-1])
+		after := end == len(text) || !isWordByte(text[end])
+		if before && after {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '_'
+}
+
+// aiRecommendation asks the model, reporting false when it isn't
+// configured, the quota is used up or its answer is unusable.
+func aiRecommendation(ctx context.Context, db *sql.DB, principal middleware.Principal, task types.Task, candidates []candidate) (types.TaskRecommendation, bool) {
+	if err := ai.CheckQuotaInStore(db, principal.OrgID, principal.UserID); err != nil {
+		fmt.Println(err)
+		return types.TaskRecommendation{}, false
+	}
+
+	redactor, err := ai.GetOrgRedactor(db, task.OrgID)
+	if err != nil {
+		fmt.Println(err)
+		return types.TaskRecommendation{}, false
+	}
+
+	gemini, err := ai.NewGeminiClient()
+	if err != nil {
+		return types.TaskRecommendation{}, false
+	}
+	defer gemini.Close()
+
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+	described := make([]ai.RecommendCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		rc := ai.RecommendCandidate{
+			UserID:    c.profile.ID,
+			Name:      strings.TrimSpace(c.profile.FirstName + " " + c.profile.LastName),
+			OpenTasks: c.openTasks,
+		}
+		for _, t := range c.similar {
+			rc.RecentTasks = append(rc.RecentTasks, t.Title)
+		}
+		described = append(described, rc)
+	}
+
+	rec, usage, err := gemini.RecommendTask(ctx, task, described, redactor)
+	ai.RecordUsage(db, principal, task.TaskID, ai.FeatureRecommend, usage, err == nil)
+	if err != nil {
+		fmt.Println(err)
+		return types.TaskRecommendation{}, false
+	}
+
+	return types.TaskRecommendation{
+		Priority:   rec.Priority,
+		AssigneeID: rec.AssigneeID,
+		Rationale:  rec.Rationale,
+		Source:     types.RecommendationAI,
+	}, true
+}
@@ -0,0 +1,176 @@
+package tasks
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RecommendTask suggests a priority and assignee for an existing task and
+// stores the suggestion for the user to accept.
+func RecommendTask(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		taskID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid task ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, taskID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Task not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve task",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionAnalyze, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to analyze this task",
+			})
+		}
+
+		rec, err := recommendForTask(c.UserContext(), db, principal, task, []string{types.RecommendPriority, types.RecommendAssignee})
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to recommend priority and assignee",
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(rec)
+	}
+}
+
+// ListRecommendations returns the recommendations made for a task, newest
+// first.
+func ListRecommendations(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		taskID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid task ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, taskID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Task not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve task",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionRead, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view this task",
+			})
+		}
+
+		recs, err := ListRecommendationsFromStore(db, task.TaskID)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve recommendations",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(recs)
+	}
+}
+
+// AcceptRecommendation applies a recommendation to its task.
+func AcceptRecommendation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		taskID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid task ID",
+			})
+		}
+		recommendationID, err := c.ParamsInt("recommendationId")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid recommendation ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, taskID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Task not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve task",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionUpdate, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to update this task",
+			})
+		}
+
+		recs, err := ListRecommendationsFromStore(db, task.TaskID)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve recommendations",
+			})
+		}
+		// The recommended assignee may have left or been deactivated since
+		for _, rec := range recs {
+			if rec.RecommendationID == recommendationID && slices.Contains(rec.Fields, types.RecommendAssignee) {
+				if err := validateAssignee(db, principal.OrgID, rec.AssigneeID); err != nil {
+					return assigneeError(c, err)
+				}
+			}
+		}
+
+		task, err = AcceptRecommendationInStore(db, task, recommendationID, principal.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Recommendation not found",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to accept recommendation",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(task)
+	}
+}
@@ -0,0 +1,200 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxDuplicates = 5
+	// maxEmbeddingsScanned bounds the in-process similarity search
+	maxEmbeddingsScanned = 5000
+	defaultSimilarLimit  = 5
+	maxSimilarLimit      = 50
+)
+
+// duplicateThreshold is the similarity above which a new task is reported
+// as a possible duplicate, SIMILAR_TASK_THRESHOLD or the embedder's own.
+func duplicateThreshold() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("SIMILAR_TASK_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		return v
+	}
+	return ai.GetEmbedder().DuplicateThreshold()
+}
+
+// UsesRemoteEmbedder reports whether tasks are embedded by a model, in
+// which case looking up similar tasks counts against the AI budget.
+func UsesRemoteEmbedder(c *fiber.Ctx) bool {
+	return ai.GetEmbedder().Remote()
+}
+
+// embedText embeds the text of task for principal. A remote embedder is
+// only called while the AI quota allows, gets the text redacted like
+// prompts are, and has its usage recorded.
+func embedText(ctx context.Context, db *sql.DB, principal middleware.Principal, task types.Task) ([]float32, error) {
+	embedder := ai.GetEmbedder()
+	text := task.Title + "\n" + task.Description
+	if !embedder.Remote() {
+		vec, _, err := embedder.Embed(ctx, text)
+		return vec, err
+	}
+
+	if err := ai.CheckQuotaInStore(db, principal.OrgID, principal.UserID); err != nil {
+		return nil, err
+	}
+	redactor, err := ai.GetOrgRedactor(db, task.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	text, redaction := redactor.Redact(text)
+
+	vec, usage, err := embedder.Embed(ctx, text)
+	usage.Redactions = redaction.Total()
+	ai.RecordUsage(db, principal, task.TaskID, ai.FeatureEmbed, usage, err == nil)
+	return vec, err
+}
+
+// embedTask computes and stores the embedding of task's title and
+// description.
+func embedTask(ctx context.Context, db *sql.DB, principal middleware.Principal, task types.Task) ([]float32, error) {
+	vec, err := embedText(ctx, db, principal, task)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveEmbeddingInStore(db, task, ai.GetEmbedder().Name(), vec); err != nil {
+		return nil, err
+	}
+	return vec, nil
+}
+
+// findSimilarTasks returns up to limit other tasks of task's organization
+// scoring at least minScore against vec, best first.
+func findSimilarTasks(db *sql.DB, task types.Task, vec []float32, minScore float64, limit int) ([]types.SimilarTask, error) {
+	embeddings, err := ListEmbeddingsFromStore(db, task.OrgID, ai.GetEmbedder().Name(), maxEmbeddingsScanned)
+	if err != nil {
+		return nil, err
+	}
+
+	similar := []types.SimilarTask{}
+	for _, te := range embeddings {
+		if te.task.TaskID == task.TaskID {
+			continue
+		}
+		score, err := ai.CosineSimilarity(vec, te.embedding)
+		if err != nil || score < minScore {
+			continue
+		}
+		te.task.Score = score
+		similar = append(similar, te.task)
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		return similar[i].Score > similar[j].Score
+	})
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+// possibleDuplicates embeds a task and returns the existing tasks that
+// read like it. The embedding is stored unless the task is only a draft
+// without an ID. Failures are logged and reported as no duplicates so they
+// never block creating the task.
+func possibleDuplicates(ctx context.Context, db *sql.DB, principal middleware.Principal, task types.Task) []types.SimilarTask {
+	var vec []float32
+	var err error
+	if task.TaskID == 0 {
+		vec, err = embedText(ctx, db, principal, task)
+	} else {
+		vec, err = embedTask(ctx, db, principal, task)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	duplicates, err := findSimilarTasks(db, task, vec, duplicateThreshold(), maxDuplicates)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return duplicates
+}
+
+// SimilarTasks lists the tasks that read most like the given one, with
+// ?limit= (default 5, at most 50) and ?min_score= between 0 and 1.
+func SimilarTasks(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		taskID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid task ID",
+			})
+		}
+
+		limit := c.QueryInt("limit", defaultSimilarLimit)
+		if limit <= 0 || limit > maxSimilarLimit {
+			limit = defaultSimilarLimit
+		}
+		minScore, err := strconv.ParseFloat(c.Query("min_score", "0"), 64)
+		if err != nil || minScore < 0 || minScore > 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "min_score must be between 0 and 1",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, taskID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Task not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve task",
+			})
+		}
+
+		if !authz.Can(principal, authz.ActionRead, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view this task",
+			})
+		}
+
+		// Tasks created before embeddings, or under another embedder, are
+		// embedded on first use
+		vec, err := GetEmbeddingFromStore(db, task.TaskID, ai.GetEmbedder().Name())
+		if err == sql.ErrNoRows {
+			vec, err = embedTask(c.UserContext(), db, principal, task)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return ai.WriteError(c, err, "Failed to embed task")
+		}
+
+		similar, err := findSimilarTasks(db, task, vec, minScore, limit)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to find similar tasks",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(similar)
+	}
+}
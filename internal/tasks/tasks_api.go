@@ -2,15 +2,27 @@ package tasks
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/jobs"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/projects"
 	users "github.com/adarsh-jaiss/zocket/internal/user"
 	"github.com/adarsh-jaiss/zocket/types"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 )
 
+// WantsRecommendation reports whether a create request asked for a
+// priority and assignee recommendation with ?recommend=true.
+func WantsRecommendation(c *fiber.Ctx) bool {
+	return c.QueryBool("recommend")
+}
+
 func CreateTask(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var task types.Task
@@ -20,11 +32,42 @@ func CreateTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		// Get user ID from JWT token
-		token := c.Locals("user").(*jwt.Token)
-		claims := token.Claims.(jwt.MapClaims)
-		userID := int(claims["user_id"].(float64))
-		task.CreatedBy = userID
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionCreate, authz.Resource{Kind: authz.ResourceTask}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to create tasks",
+			})
+		}
+		task.OrgID = principal.OrgID
+		task.CreatedBy = principal.UserID
+
+		if err := validateAssignee(db, principal.OrgID, task.AssignedTo); err != nil {
+			return assigneeError(c, err)
+		}
+		if !validDueDate(task.DueDate) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Due date must be formatted as YYYY-MM-DD",
+			})
+		}
+		if msg := validateEstimate(&task); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
+		// Recommendations are only made for what the caller left out
+		var recommend []string
+		if WantsRecommendation(c) {
+			if task.Priority == "" {
+				recommend = append(recommend, types.RecommendPriority)
+			}
+			if task.AssignedTo == 0 {
+				recommend = append(recommend, types.RecommendAssignee)
+			}
+		}
 
 		// Set default values if not provided
 		if task.Status == "" {
@@ -34,14 +77,38 @@ func CreateTask(db *sql.DB) fiber.Handler {
 			task.Priority = types.Medium
 		}
 
-		taskID, err := CreateTaskInStore(db, task)
+		task, err = CreateTaskInStore(db, task)
 		if err != nil {
+			if err == ErrProjectUnavailable {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Project not found or archived",
+				})
+			}
+			fmt.Println(err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to create task",
 			})
 		}
 
-		task.TaskID = taskID
+		task.PossibleDuplicates = possibleDuplicates(c.UserContext(), db, principal, task)
+
+		if len(recommend) > 0 {
+			// The task exists either way; a failed recommendation only
+			// leaves it out of the response
+			rec, err := recommendForTask(c.UserContext(), db, principal, task, recommend)
+			if err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+					"task":           task,
+					"recommendation": nil,
+				})
+			}
+			return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+				"task":           task,
+				"recommendation": rec,
+			})
+		}
+
 		return c.Status(fiber.StatusCreated).JSON(task)
 	}
 }
@@ -55,7 +122,12 @@ func GetTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		task, err := GetTaskFromStore(db, taskID)
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		task, err := GetTaskFromStore(db, principal.OrgID, taskID)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -67,6 +139,12 @@ func GetTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
+		if !authz.Can(principal, authz.ActionRead, authz.TaskResource(task)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not authorized to view this task",
+			})
+		}
+
 		return c.Status(fiber.StatusOK).JSON(task)
 	}
 }
@@ -87,8 +165,13 @@ func UpdateTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
 		// Get existing task to check ownership
-		existingTask, err := GetTaskFromStore(db, taskID)
+		existingTask, err := GetTaskFromStore(db, principal.OrgID, taskID)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -100,29 +183,50 @@ func UpdateTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		// Get user ID from JWT token
-		token := c.Locals("user").(*jwt.Token)
-		claims := token.Claims.(jwt.MapClaims)
-		userID := int(claims["user_id"].(float64))
-
-		// Only allow task creator or assignee to update
-		if existingTask.CreatedBy != userID && existingTask.AssignedTo != userID {
+		// Admins can update any task, members only the ones they own or are assigned to
+		if !authz.Can(principal, authz.ActionUpdate, authz.TaskResource(existingTask)) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Not authorized to update this task",
 			})
 		}
 
+		if err := validateAssignee(db, principal.OrgID, task.AssignedTo); err != nil {
+			return assigneeError(c, err)
+		}
+		if !validDueDate(task.DueDate) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Due date must be formatted as YYYY-MM-DD",
+			})
+		}
+		if msg := validateEstimate(&task); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+
 		task.TaskID = taskID
+		task.OrgID = existingTask.OrgID
+		task.ProjectID = existingTask.ProjectID
+		task.Key = existingTask.Key
 		task.CreatedBy = existingTask.CreatedBy
 		task.CreatedAt = existingTask.CreatedAt
 
-		if err := UpdateTaskInStore(db, task); err != nil {
+		if err := UpdateTaskInStore(db, task, principal.UserID, estimateGiven(c, task)); err != nil {
 			fmt.Println(err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to update task",
 			})
 		}
 
+		// Keep the embedding in step with the text it was made from
+		if task.Title != "" || task.Description != "" {
+			if updated, err := GetTaskFromStore(db, task.OrgID, task.TaskID); err != nil {
+				fmt.Println(err)
+			} else if _, err := embedTask(c.UserContext(), db, principal, updated); err != nil {
+				fmt.Println(err)
+			}
+		}
+
 		return c.Status(fiber.StatusOK).JSON(task)
 	}
 }
@@ -136,8 +240,13 @@ func DeleteTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
 		// Get existing task to check ownership
-		existingTask, err := GetTaskFromStore(db, taskID)
+		existingTask, err := GetTaskFromStore(db, principal.OrgID, taskID)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -149,19 +258,14 @@ func DeleteTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		// Get user ID from JWT token
-		token := c.Locals("user").(*jwt.Token)
-		claims := token.Claims.(jwt.MapClaims)
-		userID := int(claims["user_id"].(float64))
-
-		// Only allow task creator to delete
-		if existingTask.CreatedBy != userID {
+		// Admins can delete any task, members only the ones they created
+		if !authz.Can(principal, authz.ActionDelete, authz.TaskResource(existingTask)) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Not authorized to delete this task",
 			})
 		}
 
-		if err := DeleteTaskFromStore(db, taskID); err != nil {
+		if err := DeleteTaskFromStore(db, principal.OrgID, taskID); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to delete task",
 			})
@@ -173,42 +277,126 @@ func DeleteTask(db *sql.DB) fiber.Handler {
 	}
 }
 
+// ListTasks returns the active organization's tasks. Supports the
+// project_id, status, priority, assigned_to and created_by query filters.
 func ListTasks(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		tasks, err := ListTasksFromStore(db)
+		principal, err := middleware.GetPrincipal(c)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to retrieve tasks",
+			return middleware.Unauthorized(c)
+		}
+
+		filter, err := taskFilterFromQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
 			})
 		}
 
-		users, err := users.GetAllUsers(db)
+		return listTasks(c, db, principal, filter)
+	}
+}
+
+// ListProjectTasks returns the tasks of the project in the :id param. It
+// accepts the same filters as ListTasks.
+func ListProjectTasks(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID, err := c.ParamsInt("id")
 		if err != nil {
-			fmt.Println(err)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid project ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		if _, err := projects.GetProjectFromStore(db, principal.OrgID, projectID); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Project not found",
+				})
+			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to map users to tasks",
+				"error": "Failed to retrieve project",
 			})
 		}
 
-		// Create a map of user IDs to full names
-		userMap := make(map[int]string)
-		for _, user := range users {
-			userMap[user.ID] = user.FirstName + " " + user.LastName
+		filter, err := taskFilterFromQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
 		}
+		filter.ProjectID = projectID
+
+		return listTasks(c, db, principal, filter)
+	}
+}
 
-		// Replace AssignedTo IDs with full names in tasks
-		for i := range tasks {
-			if tasks[i].AssignedTo != 0 {
-				if name, ok := userMap[tasks[i].AssignedTo]; ok {
-					tasks[i].AssignedToName = name
-				}
+func listTasks(c *fiber.Ctx, db *sql.DB, principal middleware.Principal, filter types.TaskFilter) error {
+	if !authz.Can(principal, authz.ActionList, authz.Resource{Kind: authz.ResourceTask}) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Not authorized to list tasks",
+		})
+	}
+
+	tasks, err := ListTasksFromStore(db, principal.OrgID, filter)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve tasks",
+		})
+	}
+
+	users, err := users.GetAllUsers(db, principal.OrgID)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to map users to tasks",
+		})
+	}
+
+	// Create a map of user IDs to full names
+	userMap := make(map[int]string)
+	for _, user := range users {
+		userMap[user.ID] = user.FirstName + " " + user.LastName
+	}
+
+	// Replace AssignedTo IDs with full names in tasks
+	for i := range tasks {
+		if tasks[i].AssignedTo != 0 {
+			if name, ok := userMap[tasks[i].AssignedTo]; ok {
+				tasks[i].AssignedToName = name
 			}
 		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tasks)
+}
 
-		return c.Status(fiber.StatusOK).JSON(tasks)
+func taskFilterFromQuery(c *fiber.Ctx) (types.TaskFilter, error) {
+	filter := types.TaskFilter{
+		ProjectID:  c.QueryInt("project_id"),
+		Status:     types.TaskStatus(c.Query("status")),
+		Priority:   types.TaskPriority(c.Query("priority")),
+		AssignedTo: c.QueryInt("assigned_to"),
+		CreatedBy:  c.QueryInt("created_by"),
 	}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		return types.TaskFilter{}, errors.New("Invalid status filter")
+	}
+	if filter.Priority != "" && !filter.Priority.IsValid() {
+		return types.TaskFilter{}, errors.New("Invalid priority filter")
+	}
+	return filter, nil
 }
 
+// AnalyzeTask queues an AI breakdown of the task and returns the job right
+// away. The requester is told over the websocket when it starts and
+// finishes, and can poll GET /api/v1/jobs/:id.
 func AnalyzeTask(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		taskID, err := c.ParamsInt("id")
@@ -226,9 +414,16 @@ func AnalyzeTask(db *sql.DB) fiber.Handler {
 		}
 
 		req.TaskID = taskID
+		req.Force = req.Force || c.QueryBool("force")
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		userID := principal.UserID
 
 		// Get existing task
-		task, err := GetTaskFromStore(db, req.TaskID)
+		task, err := GetTaskFromStore(db, principal.OrgID, req.TaskID)
 		if err != nil {
 			fmt.Println(err)
 			if err == sql.ErrNoRows {
@@ -241,51 +436,126 @@ func AnalyzeTask(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		// Get user ID from JWT token
-		token := c.Locals("user").(*jwt.Token)
-		claims := token.Claims.(jwt.MapClaims)
-		userID := int(claims["user_id"].(float64))
-
-		// Only allow task creator or assignee to request analysis
-		if task.CreatedBy != userID && task.AssignedTo != userID {
+		if !authz.Can(principal, authz.ActionAnalyze, authz.TaskResource(task)) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Not authorized to analyze this task",
 			})
 		}
 
-		// If additional description is provided, append it to task description
-		if req.Description != "" {
-			task.Description += "\n\nAdditional Context:\n" + req.Description
+		if ok, err := ai.CheckQuota(c, db, principal); !ok {
+			return err
 		}
-
-		// Initialize Gemini client
-		gemini, err := ai.NewGeminiClient()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to initialize AI service",
-			})
+		// Don't queue work the provider can't take right now
+		if err := ai.Available(); err != nil {
+			return ai.WriteError(c, err, "AI service is unavailable")
 		}
-		defer gemini.Close()
 
-		// Get AI analysis
-		analysis, err := gemini.AnalyzeTask(task)
+		payload, _ := json.Marshal(analyzePayload{
+			Description: req.Description,
+			Context:     req.Context,
+			Stream:      req.Stream,
+			Force:       req.Force,
+		})
+		job, created, err := jobs.Enqueue(db, types.Job{
+			Kind:    JobAnalyze,
+			OrgID:   principal.OrgID,
+			UserID:  userID,
+			TaskID:  task.TaskID,
+			Payload: payload,
+		})
 		if err != nil {
 			fmt.Println(err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to analyze task",
+				"error": "Failed to queue task analysis",
 			})
 		}
 
-		// Store suggestions in database
-		for _, suggestion := range analysis.Suggestions {
-			suggestion.TaskID = task.TaskID
-			suggestion.UserID = userID
-			if err := StoreSuggestion(db, suggestion); err != nil {
-				// Log error but continue
-				c.App().Config().ErrorHandler(c, err)
-			}
+		// An analysis already queued for this task is returned rather than
+		// starting another one
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"job_id":     job.JobID,
+			"status":     job.Status,
+			"created":    created,
+			"status_url": fmt.Sprintf("/api/v1/jobs/%d", job.JobID),
+		})
+	}
+}
+
+// validateEstimate checks the estimate fields set by a user and marks them
+// as the user's, returning what's wrong with them if anything.
+func validateEstimate(task *types.Task) string {
+	task.EstimateSource = ""
+	if p := task.EstimatePoints; p != nil && (*p < 0 || *p > ai.MaxEstimatePoints) {
+		return fmt.Sprintf("Estimate points must be between 0 and %d", ai.MaxEstimatePoints)
+	}
+	if h := task.EstimateHours; h != nil && (*h < 0 || *h > ai.MaxEstimateHours) {
+		return fmt.Sprintf("Estimate hours must be between 0 and %d", ai.MaxEstimateHours)
+	}
+	if c := task.EstimateConfidence; c != nil && (*c < 0 || *c > 1) {
+		return "Estimate confidence must be between 0 and 1"
+	}
+	if task.EstimatePoints == nil && task.EstimateHours == nil {
+		if task.EstimateConfidence != nil {
+			return "Estimate confidence needs estimate points or hours"
 		}
+		return ""
+	}
+	task.EstimateSource = types.EstimateUser
+	return ""
+}
+
+// estimateGiven reports whether an update request mentions any estimate
+// field, even as null, in which case the estimate is replaced as a whole.
+func estimateGiven(c *fiber.Ctx, task types.Task) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil {
+		return task.EstimatePoints != nil || task.EstimateHours != nil || task.EstimateConfidence != nil
+	}
+	for _, name := range []string{"estimate_points", "estimate_hours", "estimate_confidence"} {
+		if _, ok := fields[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validDueDate reports whether dueDate is empty or a YYYY-MM-DD date.
+func validDueDate(dueDate string) bool {
+	if dueDate == "" {
+		return true
+	}
+	_, err := time.Parse(types.DueDateLayout, dueDate)
+	return err == nil
+}
+
+// errAssigneeNotMember means a task was about to be assigned to someone
+// outside its organization.
+var errAssigneeNotMember = errors.New("assignee is not a member of this organization")
+
+// validateAssignee makes sure tasks are only assigned to members of the
+// task's organization. It returns errAssigneeNotMember when userID isn't
+// one, or the error that kept it from finding out.
+func validateAssignee(db *sql.DB, orgID, userID int) error {
+	if userID == 0 {
+		return nil
+	}
+	_, err := authz.GetUserRoleFromStore(db, orgID, userID)
+	if err == sql.ErrNoRows {
+		return errAssigneeNotMember
+	}
+	return err
+}
 
-		return c.Status(fiber.StatusOK).JSON(analysis)
+// assigneeError answers a failed validateAssignee: 400 when the assignee
+// isn't a member, 500 otherwise.
+func assigneeError(c *fiber.Ctx, err error) error {
+	if err == errAssigneeNotMember {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Assignee is not a member of this organization",
+		})
 	}
+	fmt.Println(err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to check assignee",
+	})
 }
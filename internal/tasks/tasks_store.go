@@ -3,69 +3,163 @@ package tasks
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/adarsh-jaiss/zocket/internal/history"
 	"github.com/adarsh-jaiss/zocket/internal/websocket"
 	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/lib/pq"
 )
 
-func CreateTaskInStore(db *sql.DB, task types.Task) (int, error) {
+var (
+	ErrProjectUnavailable = errors.New("project not found or archived")
+)
+
+// taskColumns is the column list shared by task queries, in scanTask order.
+const taskColumns = `
+	task_id, org_id, COALESCE(project_id, 0), COALESCE(task_key, ''), title, priority, status,
+	COALESCE(assigned_to, 0), COALESCE(description, ''), COALESCE(TO_CHAR(due_date, 'YYYY-MM-DD'), ''),
+	estimate_points, estimate_hours, estimate_confidence,
+	COALESCE(estimate_source, ''), created_by, created_at, updated_at
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTask(row rowScanner) (types.Task, error) {
+	var task types.Task
+	err := row.Scan(
+		&task.TaskID,
+		&task.OrgID,
+		&task.ProjectID,
+		&task.Key,
+		&task.Title,
+		&task.Priority,
+		&task.Status,
+		&task.AssignedTo,
+		&task.Description,
+		&task.DueDate,
+		&task.EstimatePoints,
+		&task.EstimateHours,
+		&task.EstimateConfidence,
+		&task.EstimateSource,
+		&task.CreatedBy,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+	)
+	return task, err
+}
+
+// CreateTaskInStore inserts a task. Tasks created in a project get the next
+// key in that project's sequence, e.g. ZKT-123.
+func CreateTaskInStore(db *sql.DB, task types.Task) (types.Task, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Task{}, err
+	}
+	defer tx.Rollback()
+
+	task.Key = ""
+	if task.ProjectID != 0 {
+		var projectKey string
+		var seq int
+		query := `
+			UPDATE projects SET task_seq = task_seq + 1
+			WHERE project_id = $1 AND org_id = $2 AND NOT archived
+			RETURNING key, task_seq
+		`
+		err := tx.QueryRow(query, task.ProjectID, task.OrgID).Scan(&projectKey, &seq)
+		if err == sql.ErrNoRows {
+			return types.Task{}, ErrProjectUnavailable
+		}
+		if err != nil {
+			return types.Task{}, err
+		}
+		task.Key = fmt.Sprintf("%s-%d", projectKey, seq)
+	}
+
 	query := `
-		INSERT INTO tasks (title, priority, status, assigned_to, description, created_by, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
-		RETURNING task_id
+		INSERT INTO tasks (
+			org_id, project_id, task_key, title, priority, status, assigned_to, description, due_date,
+			estimate_points, estimate_hours, estimate_confidence, estimate_source, created_by, created_at, updated_at
+		)
+		VALUES (
+			$1, NULLIF($2, 0), NULLIF($3, ''), $4, $5, $6, NULLIF($7, 0), $8, NULLIF($9, '')::date,
+			$10::real, $11::real, $12::real, NULLIF($13, ''), $14, NOW(), NOW()
+		)
+		RETURNING task_id, created_at, updated_at
 	`
-	var taskID int
-	err := db.QueryRow(
+	err = tx.QueryRow(
 		query,
+		task.OrgID,
+		task.ProjectID,
+		task.Key,
 		task.Title,
 		task.Priority,
 		task.Status,
 		task.AssignedTo,
 		task.Description,
+		task.DueDate,
+		task.EstimatePoints,
+		task.EstimateHours,
+		task.EstimateConfidence,
+		task.EstimateSource,
 		task.CreatedBy,
-	).Scan(&taskID)
-
+	).Scan(&task.TaskID, &task.CreatedAt, &task.UpdatedAt)
 	if err != nil {
-		return 0, err
+		return types.Task{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Task{}, err
 	}
 
 	// Broadcast task creation
-	task.TaskID = taskID
 	taskJSON, _ := json.Marshal(map[string]interface{}{
 		"type": "task_created",
 		"data": task,
 	})
-	websocket.GetManager().BroadcastToAll(taskJSON)
+	websocket.GetManager().BroadcastToProject(task.OrgID, task.ProjectID, taskJSON)
 
-	return taskID, nil
+	return task, nil
 }
 
-func GetTaskFromStore(db *sql.DB, taskID int) (types.Task, error) {
-	var task types.Task
-	query := `
-		SELECT task_id, title, priority, status, assigned_to, description, created_by, created_at, updated_at
-		FROM tasks WHERE task_id = $1
-	`
-	err := db.QueryRow(query, taskID).Scan(
-		&task.TaskID,
-		&task.Title,
-		&task.Priority,
-		&task.Status,
-		&task.AssignedTo,
-		&task.Description,
-		&task.CreatedBy,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-	)
+func GetTaskFromStore(db *sql.DB, orgID, taskID int) (types.Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE task_id = $1 AND org_id = $2`
+	task, err := scanTask(db.QueryRow(query, taskID, orgID))
 	if err != nil {
 		return types.Task{}, err
 	}
 	return task, nil
 }
 
-func UpdateTaskInStore(db *sql.DB, task types.Task) error {
+// UpdateTaskInStore applies the non-empty fields of task and records
+// changes to its assignee, status and priority, made by userID, in the
+// task history. With setEstimate the task's estimate is replaced by the
+// one on task, or cleared if it has none.
+func UpdateTaskInStore(db *sql.DB, task types.Task, userID int, setEstimate bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var before history.TaskState
 	query := `
+		SELECT COALESCE(assigned_to, 0), status, priority
+		FROM tasks WHERE task_id = $1 AND org_id = $2
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, task.TaskID, task.OrgID).Scan(&before.Assignee, &before.Status, &before.Priority)
+	if err != nil {
+		return err
+	}
+
+	var after history.TaskState
+	query = `
 		UPDATE tasks 
 		SET 
 			title = COALESCE(NULLIF($1, ''), title), 
@@ -73,28 +167,41 @@ func UpdateTaskInStore(db *sql.DB, task types.Task) error {
 			status = CASE WHEN $3 = '' THEN status ELSE $3::task_status END,
 			assigned_to = COALESCE(NULLIF($4, 0), assigned_to), 
 			description = COALESCE(NULLIF($5, ''), description), 
+			due_date = COALESCE(NULLIF($6, '')::date, due_date),
+			estimate_points = CASE WHEN $13::boolean THEN $9::real ELSE estimate_points END,
+			estimate_hours = CASE WHEN $13::boolean THEN $10::real ELSE estimate_hours END,
+			estimate_confidence = CASE WHEN $13::boolean THEN $11::real ELSE estimate_confidence END,
+			estimate_source = CASE WHEN $13::boolean THEN NULLIF($12, '') ELSE estimate_source END,
 			updated_at = NOW()
-		WHERE task_id = $6
+		WHERE task_id = $7 AND org_id = $8
+		RETURNING COALESCE(assigned_to, 0), status, priority
 	`
-	result, err := db.Exec(
+	err = tx.QueryRow(
 		query,
 		task.Title,
 		task.Priority,
 		task.Status,
 		task.AssignedTo,
 		task.Description,
+		task.DueDate,
 		task.TaskID,
-	)
+		task.OrgID,
+		task.EstimatePoints,
+		task.EstimateHours,
+		task.EstimateConfidence,
+		task.EstimateSource,
+		setEstimate,
+	).Scan(&after.Assignee, &after.Status, &after.Priority)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+	if err := history.RecordTaskChangesInStore(tx, task.TaskID, userID, before, after); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
 	// Broadcast task update
@@ -102,45 +209,87 @@ func UpdateTaskInStore(db *sql.DB, task types.Task) error {
 		"type": "task_updated",
 		"data": task,
 	})
-	websocket.GetManager().BroadcastToAll(taskJSON)
+	websocket.GetManager().BroadcastToProject(task.OrgID, task.ProjectID, taskJSON)
 
 	return nil
 }
 
-func DeleteTaskFromStore(db *sql.DB, taskID int) error {
-	query := `DELETE FROM tasks WHERE task_id = $1`
-	result, err := db.Exec(query, taskID)
+// SetTaskEstimateInStore replaces the estimate of a task with the one on
+// task, or clears it, leaving its other fields alone, and returns the
+// updated task.
+func SetTaskEstimateInStore(db *sql.DB, task types.Task) (types.Task, error) {
+	query := `
+		UPDATE tasks
+		SET
+			estimate_points = $1::real,
+			estimate_hours = $2::real,
+			estimate_confidence = $3::real,
+			estimate_source = NULLIF($4, ''),
+			updated_at = NOW()
+		WHERE task_id = $5 AND org_id = $6
+		RETURNING ` + taskColumns
+	updated, err := scanTask(db.QueryRow(
+		query,
+		task.EstimatePoints,
+		task.EstimateHours,
+		task.EstimateConfidence,
+		task.EstimateSource,
+		task.TaskID,
+		task.OrgID,
+	))
 	if err != nil {
-		return err
+		return types.Task{}, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		fmt.Println(err)
+	// Broadcast task update
+	taskJSON, _ := json.Marshal(map[string]interface{}{
+		"type": "task_updated",
+		"data": updated,
+	})
+	websocket.GetManager().BroadcastToProject(updated.OrgID, updated.ProjectID, taskJSON)
+
+	return updated, nil
+}
+
+func DeleteTaskFromStore(db *sql.DB, orgID, taskID int) error {
+	query := `DELETE FROM tasks WHERE task_id = $1 AND org_id = $2 RETURNING COALESCE(project_id, 0)`
+	var projectID int
+	if err := db.QueryRow(query, taskID, orgID).Scan(&projectID); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		
-		return sql.ErrNoRows
-	}
 
 	// Broadcast task deletion
 	taskJSON, _ := json.Marshal(map[string]interface{}{
 		"type": "task_deleted",
 		"data": taskID,
 	})
-	websocket.GetManager().BroadcastToAll(taskJSON)
+	websocket.GetManager().BroadcastToProject(orgID, projectID, taskJSON)
 
 	return nil
 }
 
-func ListTasksFromStore(db *sql.DB) ([]types.Task, error) {
+// ListTasksFromStore returns an organization's tasks matching filter, newest first.
+func ListTasksFromStore(db *sql.DB, orgID int, filter types.TaskFilter) ([]types.Task, error) {
 	query := `
-		SELECT task_id, title, priority, status, assigned_to, description, created_by, created_at, updated_at
+		SELECT ` + taskColumns + `
 		FROM tasks
+		WHERE org_id = $1
+			AND ($2 = 0 OR project_id = $2)
+			AND ($3 = '' OR status = $3::task_status)
+			AND ($4 = '' OR priority = $4::priority_en)
+			AND ($5 = 0 OR assigned_to = $5)
+			AND ($6 = 0 OR created_by = $6)
 		ORDER BY created_at DESC
 	`
-	rows, err := db.Query(query)
+	rows, err := db.Query(
+		query,
+		orgID,
+		filter.ProjectID,
+		filter.Status,
+		filter.Priority,
+		filter.AssignedTo,
+		filter.CreatedBy,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -148,18 +297,7 @@ func ListTasksFromStore(db *sql.DB) ([]types.Task, error) {
 
 	var tasks []types.Task
 	for rows.Next() {
-		var task types.Task
-		err := rows.Scan(
-			&task.TaskID,
-			&task.Title,
-			&task.Priority,
-			&task.Status,
-			&task.AssignedTo,
-			&task.Description,
-			&task.CreatedBy,
-			&task.CreatedAt,
-			&task.UpdatedAt,
-		)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -168,7 +306,8 @@ func ListTasksFromStore(db *sql.DB) ([]types.Task, error) {
 	return tasks, nil
 }
 
-func StoreSuggestion(db *sql.DB, suggestion types.TaskSuggestion) error {
+// StoreSuggestion saves an AI suggestion for task and notifies the task's subscribers.
+func StoreSuggestion(db *sql.DB, task types.Task, suggestion types.TaskSuggestion) error {
 	// Convert subtasks to JSON for storage
 	subTasksJSON, err := json.Marshal(suggestion.SubTasks)
 	if err != nil {
@@ -176,8 +315,8 @@ func StoreSuggestion(db *sql.DB, suggestion types.TaskSuggestion) error {
 	}
 
 	query := `
-		INSERT INTO task_suggestions (task_id, user_id, suggestion_text, sub_tasks, accepted, created_at)
-		VALUES ($1, $2, $3, $4, $5, NOW())
+		INSERT INTO task_suggestions (task_id, user_id, suggestion_text, sub_tasks, prompt_version, accepted, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, NOW())
 		RETURNING suggestion_id
 	`
 
@@ -188,6 +327,7 @@ func StoreSuggestion(db *sql.DB, suggestion types.TaskSuggestion) error {
 		suggestion.UserID,
 		suggestion.SuggestionText,
 		subTasksJSON,
+		suggestion.PromptVersion,
 		suggestion.Accepted,
 	).Scan(&suggestionID)
 
@@ -201,14 +341,14 @@ func StoreSuggestion(db *sql.DB, suggestion types.TaskSuggestion) error {
 		"type": "suggestion_created",
 		"data": suggestion,
 	})
-	websocket.GetManager().BroadcastToAll(suggestionJSON)
+	websocket.GetManager().BroadcastToProject(task.OrgID, task.ProjectID, suggestionJSON)
 
 	return nil
 }
 
 func GetTaskSuggestions(db *sql.DB, taskID int) ([]types.TaskSuggestion, error) {
 	query := `
-		SELECT suggestion_id, task_id, user_id, suggestion_text, sub_tasks, accepted, created_at
+		SELECT suggestion_id, task_id, user_id, suggestion_text, sub_tasks, COALESCE(prompt_version, ''), accepted, created_at
 		FROM task_suggestions
 		WHERE task_id = $1
 		ORDER BY created_at DESC
@@ -231,6 +371,7 @@ func GetTaskSuggestions(db *sql.DB, taskID int) ([]types.TaskSuggestion, error)
 			&suggestion.UserID,
 			&suggestion.SuggestionText,
 			&subTasksJSON,
+			&suggestion.PromptVersion,
 			&suggestion.Accepted,
 			&suggestion.CreatedAt,
 		)
@@ -250,3 +391,372 @@ func GetTaskSuggestions(db *sql.DB, taskID int) ([]types.TaskSuggestion, error)
 
 	return suggestions, nil
 }
+
+// ListOpenTasksFromStore returns up to limit unfinished tasks in the same
+// project as task, or among tasks without a project, most recently updated
+// first. The task itself is left out.
+func ListOpenTasksFromStore(db *sql.DB, task types.Task, limit int) ([]types.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE org_id = $1
+			AND COALESCE(project_id, 0) = $2
+			AND task_id <> $3
+			AND status <> 'Done'
+		ORDER BY updated_at DESC
+		LIMIT $4
+	`
+	return queryTasks(db, query, task.OrgID, task.ProjectID, task.TaskID, limit)
+}
+
+// ListCompletedTasksFromStore returns up to limit tasks in task's
+// organization that were finished since the given time, most recent first.
+func ListCompletedTasksFromStore(db *sql.DB, task types.Task, since time.Time, limit int) ([]types.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE org_id = $1
+			AND task_id <> $2
+			AND status = 'Done'
+			AND updated_at >= $3
+		ORDER BY updated_at DESC
+		LIMIT $4
+	`
+	return queryTasks(db, query, task.OrgID, task.TaskID, since, limit)
+}
+
+func queryTasks(db *sql.DB, query string, args ...interface{}) ([]types.Task, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []types.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// CountOpenTasksByAssigneeFromStore returns the number of unfinished tasks
+// assigned to each member of an organization.
+func CountOpenTasksByAssigneeFromStore(db *sql.DB, orgID int) (map[int]int, error) {
+	query := `
+		SELECT assigned_to, COUNT(*)
+		FROM tasks
+		WHERE org_id = $1 AND assigned_to IS NOT NULL AND status <> 'Done'
+		GROUP BY assigned_to
+	`
+	rows, err := db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var userID, count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, err
+		}
+		counts[userID] = count
+	}
+	return counts, rows.Err()
+}
+
+// ListAssignedTasksFromStore returns up to limit of an organization's
+// assigned tasks, most recently updated first, as assignment history.
+func ListAssignedTasksFromStore(db *sql.DB, orgID, limit int) ([]types.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE org_id = $1 AND assigned_to IS NOT NULL
+		ORDER BY updated_at DESC
+		LIMIT $2
+	`
+	return queryTasks(db, query, orgID, limit)
+}
+
+const recommendationColumns = `
+	recommendation_id, task_id, user_id, priority, COALESCE(assignee_id, 0), fields, rationale, source, accepted, created_at
+`
+
+func scanRecommendation(row rowScanner) (types.TaskRecommendation, error) {
+	var rec types.TaskRecommendation
+	err := row.Scan(
+		&rec.RecommendationID,
+		&rec.TaskID,
+		&rec.UserID,
+		&rec.Priority,
+		&rec.AssigneeID,
+		pq.Array(&rec.Fields),
+		&rec.Rationale,
+		&rec.Source,
+		&rec.Accepted,
+		&rec.CreatedAt,
+	)
+	return rec, err
+}
+
+func CreateRecommendationInStore(db *sql.DB, rec types.TaskRecommendation) (types.TaskRecommendation, error) {
+	query := `
+		INSERT INTO task_recommendations (task_id, user_id, priority, assignee_id, fields, rationale, source, accepted, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7, FALSE, NOW())
+		RETURNING ` + recommendationColumns
+	return scanRecommendation(db.QueryRow(
+		query,
+		rec.TaskID,
+		rec.UserID,
+		rec.Priority,
+		rec.AssigneeID,
+		pq.Array(rec.Fields),
+		rec.Rationale,
+		rec.Source,
+	))
+}
+
+func ListRecommendationsFromStore(db *sql.DB, taskID int) ([]types.TaskRecommendation, error) {
+	query := `
+		SELECT ` + recommendationColumns + `
+		FROM task_recommendations
+		WHERE task_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := db.Query(query, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recs := []types.TaskRecommendation{}
+	for rows.Next() {
+		rec, err := scanRecommendation(rows)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// AcceptRecommendationInStore applies the fields a recommendation was made
+// for to the task, leaving the assignee alone if none was found, marks it
+// accepted and returns the updated task. The change is recorded in the task history as made by
+// userID.
+func AcceptRecommendationInStore(db *sql.DB, task types.Task, recommendationID, userID int) (types.Task, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return types.Task{}, err
+	}
+	defer tx.Rollback()
+
+	var priority types.TaskPriority
+	var assigneeID int
+	var fields []string
+	query := `
+		UPDATE task_recommendations SET accepted = TRUE
+		WHERE recommendation_id = $1 AND task_id = $2
+		RETURNING priority, COALESCE(assignee_id, 0), fields
+	`
+	err = tx.QueryRow(query, recommendationID, task.TaskID).Scan(&priority, &assigneeID, pq.Array(&fields))
+	if err != nil {
+		return types.Task{}, err
+	}
+
+	// Suggestions for fields the user had already set are not applied
+	var setPriority, setAssignee bool
+	for _, f := range fields {
+		switch f {
+		case types.RecommendPriority:
+			setPriority = true
+		case types.RecommendAssignee:
+			setAssignee = assigneeID != 0
+		}
+	}
+
+	var before history.TaskState
+	query = `
+		SELECT COALESCE(assigned_to, 0), status, priority
+		FROM tasks WHERE task_id = $1 AND org_id = $2
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, task.TaskID, task.OrgID).Scan(&before.Assignee, &before.Status, &before.Priority)
+	if err != nil {
+		return types.Task{}, err
+	}
+
+	query = `
+		UPDATE tasks
+		SET priority = CASE WHEN $1::boolean THEN $2::priority_en ELSE priority END,
+			assigned_to = CASE WHEN $3::boolean THEN $4::integer ELSE assigned_to END,
+			updated_at = NOW()
+		WHERE task_id = $5 AND org_id = $6
+		RETURNING ` + taskColumns
+	updated, err := scanTask(tx.QueryRow(query, setPriority, priority, setAssignee, assigneeID, task.TaskID, task.OrgID))
+	if err != nil {
+		return types.Task{}, err
+	}
+
+	after := history.TaskState{Assignee: updated.AssignedTo, Status: updated.Status, Priority: updated.Priority}
+	if err := history.RecordTaskChangesInStore(tx, task.TaskID, userID, before, after); err != nil {
+		return types.Task{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Task{}, err
+	}
+
+	// Broadcast task update
+	taskJSON, _ := json.Marshal(map[string]interface{}{
+		"type": "task_updated",
+		"data": updated,
+	})
+	websocket.GetManager().BroadcastToProject(updated.OrgID, updated.ProjectID, taskJSON)
+
+	return updated, nil
+}
+
+// SaveEmbeddingInStore stores a task's embedding, replacing any earlier one.
+func SaveEmbeddingInStore(db *sql.DB, task types.Task, model string, embedding []float32) error {
+	query := `
+		INSERT INTO task_embeddings (task_id, org_id, model, embedding, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (task_id) DO UPDATE
+		SET model = EXCLUDED.model, embedding = EXCLUDED.embedding, updated_at = NOW()
+	`
+	_, err := db.Exec(query, task.TaskID, task.OrgID, model, pq.Float32Array(embedding))
+	return err
+}
+
+// GetEmbeddingFromStore returns a task's embedding if it was made by model.
+func GetEmbeddingFromStore(db *sql.DB, taskID int, model string) ([]float32, error) {
+	var embedding pq.Float32Array
+	query := `SELECT embedding FROM task_embeddings WHERE task_id = $1 AND model = $2`
+	if err := db.QueryRow(query, taskID, model).Scan(&embedding); err != nil {
+		return nil, err
+	}
+	return embedding, nil
+}
+
+// taskEmbedding is a task with its embedding, for similarity search.
+type taskEmbedding struct {
+	task      types.SimilarTask
+	embedding []float32
+}
+
+// ListEmbeddingsFromStore returns up to limit embeddings made by model for
+// an organization's tasks, most recently updated tasks first.
+func ListEmbeddingsFromStore(db *sql.DB, orgID int, model string, limit int) ([]taskEmbedding, error) {
+	query := `
+		SELECT t.task_id, COALESCE(t.task_key, ''), t.title, t.status, e.embedding
+		FROM task_embeddings e
+		JOIN tasks t ON t.task_id = e.task_id
+		WHERE e.org_id = $1 AND e.model = $2
+		ORDER BY t.updated_at DESC
+		LIMIT $3
+	`
+	rows, err := db.Query(query, orgID, model, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var embeddings []taskEmbedding
+	for rows.Next() {
+		var te taskEmbedding
+		var embedding pq.Float32Array
+		if err := rows.Scan(&te.task.TaskID, &te.task.Key, &te.task.Title, &te.task.Status, &embedding); err != nil {
+			return nil, err
+		}
+		te.embedding = embedding
+		embeddings = append(embeddings, te)
+	}
+	return embeddings, rows.Err()
+}
+
+// GetCachedAnalysisFromStore returns an unexpired breakdown stored for the
+// task under the same content hash, prompt version and model.
+func GetCachedAnalysisFromStore(db *sql.DB, taskID int, contentHash, promptVersion, model string) (*types.AITaskBreakdownResponse, error) {
+	query := `
+		SELECT response FROM analysis_cache
+		WHERE task_id = $1 AND content_hash = $2 AND prompt_version = $3 AND model = $4
+			AND expires_at > NOW()
+	`
+	var response []byte
+	if err := db.QueryRow(query, taskID, contentHash, promptVersion, model).Scan(&response); err != nil {
+		return nil, err
+	}
+
+	var analysis types.AITaskBreakdownResponse
+	if err := json.Unmarshal(response, &analysis); err != nil {
+		return nil, err
+	}
+	return &analysis, nil
+}
+
+// SaveAnalysisCacheInStore stores a breakdown for ttl, replacing an older
+// entry for the same key, and drops the task's expired entries.
+func SaveAnalysisCacheInStore(db *sql.DB, taskID int, contentHash, promptVersion, model string, analysis *types.AITaskBreakdownResponse, ttl time.Duration) error {
+	response, err := json.Marshal(analysis)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO analysis_cache (task_id, content_hash, prompt_version, model, response, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW() + make_interval(secs => $6))
+		ON CONFLICT (task_id, content_hash, prompt_version, model) DO UPDATE SET
+			response = EXCLUDED.response,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+	`
+	if _, err := db.Exec(query, taskID, contentHash, promptVersion, model, response, ttl.Seconds()); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`DELETE FROM analysis_cache WHERE task_id = $1 AND expires_at <= NOW()`, taskID)
+	return err
+}
+
+// taskCycle is when work on a completed task started and when it was done.
+type taskCycle struct {
+	startedAt time.Time
+	doneAt    time.Time
+}
+
+// ListTaskCyclesFromStore returns the cycles of those of taskIDs that were
+// moved to Done: from their first move to InProgress, or their creation if
+// there was none, to their last move to Done.
+func ListTaskCyclesFromStore(db *sql.DB, taskIDs []int) (map[int]taskCycle, error) {
+	query := `
+		SELECT t.task_id,
+			COALESCE(MIN(u.updated_at) FILTER (WHERE u.new_status = 'InProgress'), t.created_at),
+			MAX(u.updated_at) FILTER (WHERE u.new_status = 'Done')
+		FROM tasks t
+		JOIN task_updates u ON u.task_id = t.task_id AND u.change_type = 'Status'
+		WHERE t.task_id = ANY($1)
+		GROUP BY t.task_id, t.created_at
+		HAVING MAX(u.updated_at) FILTER (WHERE u.new_status = 'Done') IS NOT NULL
+	`
+	rows, err := db.Query(query, pq.Array(taskIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cycles := map[int]taskCycle{}
+	for rows.Next() {
+		var taskID int
+		var cycle taskCycle
+		if err := rows.Scan(&taskID, &cycle.startedAt, &cycle.doneAt); err != nil {
+			return nil, err
+		}
+		cycles[taskID] = cycle
+	}
+	return cycles, rows.Err()
+}
@@ -0,0 +1,160 @@
+package users
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed sign-in attempts are counted per account (by email, whether or not
+// it exists) and per client IP. Once a counter reaches its threshold the key
+// is locked, first for the base duration and twice as long for every further
+// failure, up to the maximum. Counters live in Postgres so every replica
+// sees the same state.
+const (
+	lockScopeAccount = "account"
+	lockScopeIP      = "ip"
+
+	defaultMaxAccountFailures = 5
+	defaultMaxIPFailures      = 20
+	defaultLockoutBase        = 30 * time.Second
+	defaultLockoutMax         = time.Hour
+
+	// failureWindow is how long a failure counts against a key
+	failureWindow = 24 * time.Hour
+)
+
+type lockoutPolicy struct {
+	maxAccountFailures int
+	maxIPFailures      int
+	base               time.Duration
+	max                time.Duration
+}
+
+// getLockoutPolicy reads LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES,
+// LOGIN_LOCKOUT_BASE_SECONDS and LOGIN_LOCKOUT_MAX_SECONDS.
+func getLockoutPolicy() lockoutPolicy {
+	return lockoutPolicy{
+		maxAccountFailures: envInt("LOGIN_MAX_FAILURES", defaultMaxAccountFailures),
+		maxIPFailures:      envInt("LOGIN_IP_MAX_FAILURES", defaultMaxIPFailures),
+		base:               time.Duration(envInt("LOGIN_LOCKOUT_BASE_SECONDS", int(defaultLockoutBase.Seconds()))) * time.Second,
+		max:                time.Duration(envInt("LOGIN_LOCKOUT_MAX_SECONDS", int(defaultLockoutMax.Seconds()))) * time.Second,
+	}
+}
+
+// lockoutFor returns how long a key with the given number of failures stays
+// locked, or zero while it is below threshold.
+func (p lockoutPolicy) lockoutFor(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	exp := failures - threshold
+	if exp > 30 {
+		return p.max
+	}
+	d := p.base * time.Duration(math.Pow(2, float64(exp)))
+	if d > p.max {
+		d = p.max
+	}
+	return d
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// GetLockoutFromStore returns how long the account or the IP is still
+// locked, or zero when neither is.
+func GetLockoutFromStore(db *sql.DB, email, ip string) (time.Duration, error) {
+	var seconds float64
+	query := `
+		SELECT COALESCE(MAX(EXTRACT(EPOCH FROM locked_until - NOW())), 0)
+		FROM login_attempts
+		WHERE ((scope = $1 AND key = $2) OR (scope = $3 AND key = $4))
+			AND locked_until > NOW()
+	`
+	err := db.QueryRow(query, lockScopeAccount, accountKey(email), lockScopeIP, ip).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(math.Ceil(seconds)) * time.Second, nil
+}
+
+// RecordFailedSignInInStore counts a failed attempt against the account and
+// the IP and locks whichever crossed its threshold.
+func RecordFailedSignInInStore(db *sql.DB, email, ip string) error {
+	policy := getLockoutPolicy()
+	if err := recordFailure(db, lockScopeAccount, accountKey(email), policy, policy.maxAccountFailures); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return recordFailure(db, lockScopeIP, ip, policy, policy.maxIPFailures)
+}
+
+func recordFailure(db *sql.DB, scope, key string, policy lockoutPolicy, threshold int) error {
+	var failures int
+	query := `
+		INSERT INTO login_attempts (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure_at < NOW() - $3 * INTERVAL '1 second' THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures
+	`
+	err := db.QueryRow(query, scope, key, int(failureWindow.Seconds())).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	lockout := policy.lockoutFor(failures, threshold)
+	if lockout == 0 {
+		return nil
+	}
+
+	query = `
+		UPDATE login_attempts
+		SET locked_until = NOW() + $3 * INTERVAL '1 second'
+		WHERE scope = $1 AND key = $2
+	`
+	_, err = db.Exec(query, scope, key, int(lockout.Seconds()))
+	return err
+}
+
+// ClearAccountLockoutInStore resets the failure counter of an account, after
+// a successful sign-in or when an admin unlocks it. IP counters are left
+// alone so one valid account can't be used to reset them.
+func ClearAccountLockoutInStore(db *sql.DB, email string) error {
+	query := `DELETE FROM login_attempts WHERE scope = $1 AND key = $2`
+	_, err := db.Exec(query, lockScopeAccount, accountKey(email))
+	return err
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// burnPasswordCheck spends about as long as checking a real password so that
+// unknown emails can't be told apart by response time.
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("zocket-timing-equalizer")
+	})
+	CheckPassword(dummyHash, password)
+}
@@ -0,0 +1,37 @@
+package users
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 6
+
+// disabledPassword is stored for accounts that must never sign in with a
+// password. CheckPassword rejects it and anything else starting with "!".
+const disabledPassword = "!disabled"
+
+// HashPassword hashes a password for storage.
+func HashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// CheckPassword compares a stored password with a candidate. Accounts created
+// before passwords were hashed still hold plain text; for those needsRehash
+// is true so the caller can upgrade them.
+func CheckPassword(stored, candidate string) (ok bool, needsRehash bool) {
+	if stored == "" || strings.HasPrefix(stored, "!") {
+		return false, false
+	}
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(candidate)) == nil, false
+	}
+	ok = subtle.ConstantTimeCompare([]byte(stored), []byte(candidate)) == 1
+	return ok, ok
+}
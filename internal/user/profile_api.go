@@ -0,0 +1,521 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/notifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	verifyEmailAudience = "zocket-verify-email"
+	verifyEmailTTL      = 24 * time.Hour
+)
+
+// VerifyEmailClaims is the signed payload of an email verification link.
+type VerifyEmailClaims struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func (c VerifyEmailClaims) Valid() error {
+	if err := c.RegisteredClaims.Valid(); err != nil {
+		return err
+	}
+	if !c.VerifyAudience(verifyEmailAudience, true) || c.UserID == 0 || c.Email == "" {
+		return jwt.ErrTokenInvalidClaims
+	}
+	return nil
+}
+
+// UpdateProfileRequest represents the request body for updating the caller's
+// profile. Omitted fields are left unchanged.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+}
+
+// ChangePasswordRequest represents the request body for changing a password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+// DeactivateRequest represents the request body for deactivating an account.
+// Open tasks go to ReassignTo where possible and are unassigned otherwise.
+type DeactivateRequest struct {
+	ReassignTo int `json:"reassign_to"`
+}
+
+// DeleteAccountRequest represents the request body for deleting the caller's
+// account
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// VerifyEmailRequest represents the request body for confirming an email
+// change
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// GetMe returns the caller's own profile
+func GetMe(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		user, err := GetUserFromStore(db, principal.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "User not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(user)
+	}
+}
+
+// UpdateMe updates the caller's name and email. A new email only takes
+// effect once the link sent to it has been followed.
+func UpdateMe(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req UpdateProfileRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		user, err := GetUserFromStore(db, principal.UserID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user",
+			})
+		}
+
+		var firstName, lastName string
+		if req.FirstName != nil {
+			firstName = strings.TrimSpace(*req.FirstName)
+		}
+		if req.LastName != nil {
+			lastName = strings.TrimSpace(*req.LastName)
+		}
+		if firstName != "" || lastName != "" {
+			if err := UpdateNameInStore(db, user.ID, firstName, lastName); err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to update profile",
+				})
+			}
+		}
+
+		verificationSent := false
+		if req.Email != nil {
+			email := strings.ToLower(strings.TrimSpace(*req.Email))
+			if !strings.Contains(email, "@") || IsReservedEmail(email) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid email",
+				})
+			}
+			if !strings.EqualFold(email, user.Email) {
+				if err := SetPendingEmailInStore(db, user.ID, email); err != nil {
+					if err == ErrEmailExists {
+						return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+							"error": "Email already exists",
+						})
+					}
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+						"error": "Failed to update profile",
+					})
+				}
+				if err := sendEmailVerification(user.ID, email); err != nil {
+					fmt.Println(err)
+				} else {
+					verificationSent = true
+				}
+			}
+		}
+
+		user, err = GetUserFromStore(db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"user":              user,
+			"verification_sent": verificationSent,
+		})
+	}
+}
+
+// VerifyEmail confirms a pending email change from the link sent to the new
+// address. Tokens issued before the change carry the old email and keep
+// working until they expire.
+func VerifyEmail(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req VerifyEmailRequest
+		if err := c.BodyParser(&req); err != nil || req.Token == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		claims := &VerifyEmailClaims{}
+		if err := middleware.ParseClaims(req.Token, claims); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired verification link",
+			})
+		}
+
+		if err := ConfirmEmailInStore(db, claims.UserID, claims.Email); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid or expired verification link",
+				})
+			case ErrEmailExists:
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Email already exists",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify email",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Email verified successfully",
+		})
+	}
+}
+
+// ChangePassword sets a new password for the caller after checking the
+// current one
+func ChangePassword(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req ChangePasswordRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if len(req.NewPassword) < minPasswordLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength),
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		if ok, err := checkCurrentPassword(c, db, principal.UserID, req.CurrentPassword); !ok {
+			return err
+		}
+
+		hashed, err := HashPassword(req.NewPassword)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to process password",
+			})
+		}
+		if err := UpdatePasswordInStore(db, principal.UserID, hashed); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to change password",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Password changed successfully",
+		})
+	}
+}
+
+// DeactivateMe deactivates the caller's own account in every organization
+func DeactivateMe(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		return deactivate(c, db, principal.UserID, 0, principal.UserID)
+	}
+}
+
+// DeactivateUser suspends a member's access to the active organization. The
+// account itself stays usable elsewhere. Admin only.
+func DeactivateUser(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if ok, err := checkManagedMember(c, db, principal, userID); !ok {
+			return err
+		}
+
+		return deactivate(c, db, principal.UserID, principal.OrgID, userID)
+	}
+}
+
+// ReactivateUser gives a suspended member access to the active organization
+// again. Tasks that were reassigned stay where they are. Admin only.
+func ReactivateUser(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if ok, err := checkManagedMember(c, db, principal, userID); !ok {
+			return err
+		}
+
+		if err := ReactivateMemberInStore(db, principal.OrgID, userID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to reactivate user",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "User reactivated successfully",
+		})
+	}
+}
+
+// UnlockUser clears the failed sign-in counter of a member of the active
+// organization so they can try again right away. The counter guards the
+// account everywhere, so only users who belong to no other organization
+// can be unlocked. Admin only.
+func UnlockUser(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if ok, err := checkManagedMember(c, db, principal, userID); !ok {
+			return err
+		}
+
+		other, err := HasOtherOrgsInStore(db, principal.OrgID, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user",
+			})
+		}
+		if other {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "User belongs to other organizations and can only be unlocked by waiting",
+			})
+		}
+
+		user, err := GetUserFromStore(db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve user",
+			})
+		}
+		if err := ClearAccountLockoutInStore(db, user.Email); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to unlock user",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "User unlocked successfully",
+		})
+	}
+}
+
+// DeleteMe permanently deletes the caller's account. Authored tasks are kept
+// but attributed to an anonymous placeholder user.
+func DeleteMe(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req DeleteAccountRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+
+		if ok, err := checkCurrentPassword(c, db, principal.UserID, req.Password); !ok {
+			return err
+		}
+
+		if err := DeleteUserFromStore(db, principal.UserID); err != nil {
+			if err == ErrSoleAdmin {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Make another member an admin before deleting your account",
+				})
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete account",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Account deleted successfully",
+		})
+	}
+}
+
+// deactivate suspends userID in orgID, or deactivates the whole account when
+// orgID is 0, on behalf of actorID.
+func deactivate(c *fiber.Ctx, db *sql.DB, actorID, orgID, userID int) error {
+	var req DeactivateRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if req.ReassignTo == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot reassign tasks to the deactivated user",
+		})
+	}
+
+	var moved int64
+	var err error
+	if orgID == 0 {
+		moved, err = DeactivateUserInStore(db, actorID, userID, req.ReassignTo)
+	} else {
+		moved, err = SuspendMemberInStore(db, actorID, orgID, userID, req.ReassignTo)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to deactivate user",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "User deactivated successfully",
+		"tasks_released": moved,
+	})
+}
+
+// checkManagedMember makes sure the caller administers the active
+// organization and userID belongs to it. It reports whether the caller may
+// proceed; when not, it has written the error response and returns the
+// error from writing it.
+func checkManagedMember(c *fiber.Ctx, db *sql.DB, principal middleware.Principal, userID int) (bool, error) {
+	if userID == principal.UserID || !authz.Can(principal, authz.ActionUpdate, authz.OrgResource()) {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Not authorized to manage this user",
+		})
+	}
+
+	member, err := IsOrgMemberInStore(db, principal.OrgID, userID)
+	if err != nil {
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve user",
+		})
+	}
+	if !member {
+		return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	return true, nil
+}
+
+// checkCurrentPassword reports whether password matches the user's. When it
+// doesn't it writes a 401 response, like checkManagedMember.
+func checkCurrentPassword(c *fiber.Ctx, db *sql.DB, userID int, password string) (bool, error) {
+	stored, err := GetPasswordFromStore(db, userID)
+	if err != nil {
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve user",
+		})
+	}
+	if ok, _ := CheckPassword(stored, password); !ok {
+		return false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid password",
+		})
+	}
+	return true, nil
+}
+
+// sendEmailVerification mails a verification link to a user's new address.
+func sendEmailVerification(userID int, email string) error {
+	token, err := middleware.SignClaims(VerifyEmailClaims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{verifyEmailAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(verifyEmailTTL)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	base := os.Getenv("VERIFY_EMAIL_URL_BASE")
+	if base == "" {
+		base = "http://localhost:3000/verify-email"
+	}
+
+	return notifier.GetNotifier().Notify(context.Background(), notifier.Message{
+		Kind:    "verify_email",
+		To:      email,
+		Subject: "Confirm your new Zocket email address",
+		Body:    fmt.Sprintf("Follow this link within 24 hours to confirm your email: %s", base+"?token="+url.QueryEscape(token)),
+	})
+}
@@ -4,14 +4,19 @@ import (
 	"database/sql"
 	"fmt"
 	"strconv"
-	"time"
-
-	// "golang.org/x/crypto/bcrypt"
 
+	"github.com/adarsh-jaiss/zocket/internal/audit"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/invitations"
 	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/org"
 	"github.com/adarsh-jaiss/zocket/types"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
 )
 
 // SignupRequest represents the request body for user signup
@@ -20,6 +25,10 @@ type SignupRequest struct {
 	Password  string `json:"password" validate:"required,min=6"`
 	FirstName string `json:"first_name" validate:"required"`
 	LastName  string `json:"last_name" validate:"required"`
+
+	// InviteToken, when set, joins the new user to the inviting organization
+	// instead of creating a personal workspace.
+	InviteToken string `json:"invite_token,omitempty"`
 }
 
 // Signup handles user registration and returns a JWT token
@@ -31,19 +40,47 @@ func Signup(db *sql.DB) fiber.Handler {
 				"error": "Invalid request body",
 			})
 		}
+		if IsReservedEmail(req.Email) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid email",
+			})
+		}
+		if len(req.Password) < minPasswordLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength),
+			})
+		}
+
+		if req.InviteToken != "" {
+			claims, err := invitations.ParseInviteToken(req.InviteToken)
+			if err == nil {
+				err = invitations.CheckInvitationInStore(db, claims, req.Email)
+			}
+			if err != nil {
+				if err != invitations.ErrInvitationInvalid {
+					fmt.Println(err)
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+						"error": "Failed to check invitation",
+					})
+				}
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid or expired invitation",
+				})
+			}
+		}
 
 		// Hash the password
-		// hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		// if err != nil {
-		// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-		// 		"error": "Failed to process password",
-		// 	})
-		// }
+		hashedPassword, err := HashPassword(req.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to process password",
+			})
+		}
 
 		// Create user object
 		user := types.User{
 			Email:     req.Email,
-			Password:  req.Password,
+			Password:  hashedPassword,
 			FirstName: req.FirstName,
 			LastName:  req.LastName,
 		}
@@ -58,31 +95,77 @@ func Signup(db *sql.DB) fiber.Handler {
 			}
 			fmt.Println(err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				
+
 				"error": "Failed to create user",
 			})
 		}
 
-		// Generate JWT token
-		claims := jwt.MapClaims{
-			"user_id": userID,
-			"email":   user.Email,
-			"exp":     time.Now().Add(time.Hour * time.Duration(middleware.JWTExpirationHours)).Unix(),
+		var orgID, projectID int
+		var invitationError string
+		role := authz.RoleAdmin
+		if req.InviteToken != "" {
+			inv, err := invitations.AcceptInvitation(db, req.InviteToken, userID, user.Email)
+			if err != nil {
+				// The invitation changed since it was checked. The account
+				// exists now, so fall back to a personal workspace and say so.
+				fmt.Println(err)
+				invitationError = "Invalid or expired invitation"
+			} else {
+				orgID, projectID, role = inv.OrgID, inv.ProjectID, inv.Role
+				// The invitation was sent to this address, so its owner is
+				// the one signing up
+				if err := MarkEmailVerifiedInStore(db, userID, user.Email); err != nil {
+					fmt.Println(err)
+				}
+			}
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		t, err := token.SignedString([]byte(middleware.JWTSecret))
+		// Everyone else starts with a personal workspace they administer
+		if orgID == 0 {
+			orgID, err = org.CreatePersonalOrgInStore(db, userID, user.FirstName)
+			if err != nil {
+				fmt.Println(err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to create user",
+				})
+			}
+			role = authz.RoleAdmin
+		}
+
+		// Generate JWT token
+		t, err := middleware.GenerateToken(middleware.Principal{
+			UserID: userID,
+			Email:  user.Email,
+			Roles:  []string{role},
+			OrgID:  orgID,
+		})
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to generate token",
 			})
 		}
 
-		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		audit.RecordAuthEvent(db, c, types.AuthEvent{
+			UserID:  userID,
+			OrgID:   orgID,
+			Email:   user.Email,
+			Type:    audit.EventSignUp,
+			Success: true,
+		})
+
+		resp := fiber.Map{
 			"user_id": userID,
+			"org_id":  orgID,
 			"token":   t,
 			"message": "User created successfully",
-		})
+		}
+		if projectID != 0 {
+			resp["project_id"] = projectID
+		}
+		if invitationError != "" {
+			resp["invitation_error"] = invitationError
+		}
+		return c.Status(fiber.StatusCreated).JSON(resp)
 	}
 }
 
@@ -96,17 +179,29 @@ func GetUser(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		// Get user claims from JWT token
-		token := c.Locals("user").(*jwt.Token)
-		claims := token.Claims.(jwt.MapClaims)
-		tokenUserID := int(claims["user_id"].(float64))
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
 
-		// Only allow users to access their own data
-		if userID != tokenUserID {
+		// Users can access their own data, admins anyone's in their organization
+		if !authz.Can(principal, authz.ActionRead, authz.UserResource(userID)) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Access denied",
 			})
 		}
+		if userID != principal.UserID {
+			if _, err := authz.GetUserRoleFromStore(db, principal.OrgID, userID); err != nil {
+				if err == sql.ErrNoRows {
+					return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+						"error": "User not found",
+					})
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to retrieve user",
+				})
+			}
+		}
 
 		user, err := GetUserFromStore(db, userID)
 		if err != nil {
@@ -120,17 +215,12 @@ func GetUser(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		// Don't return password in response
-		user.Password = ""
 		return c.Status(fiber.StatusOK).JSON(user)
 	}
 }
 
-
-
-
 func SignIn(db *sql.DB) fiber.Handler {
-	return func (c *fiber.Ctx) error  {
+	return func(c *fiber.Ctx) error {
 		var req types.SignInRequest
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -139,50 +229,143 @@ func SignIn(db *sql.DB) fiber.Handler {
 		}
 
 		user, err := GetUserByEmailAndPassword(db, req.Email)
+		if err != nil && err != sql.ErrNoRows {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to sign in",
+			})
+		}
+		found := err == nil
+
+		lockout, err := GetLockoutFromStore(db, req.Email, c.IP())
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"error": "Invalid email",
-				})
-			}
+			fmt.Println(err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to sign in",
 			})
 		}
+		if lockout > 0 {
+			recordSignIn(db, c, req.Email, user.ID, 0, audit.ReasonLocked)
+			seconds := int(lockout.Seconds())
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "Too many failed sign-in attempts, try again later",
+				"retry_after": seconds,
+			})
+		}
+
+		// Unknown emails and wrong passwords look the same to the caller
+		if !found {
+			burnPasswordCheck(req.Password)
+			return invalidCredentials(c, db, req.Email, 0, audit.ReasonUnknownEmail)
+		}
+		ok, needsRehash := CheckPassword(user.Password, req.Password)
+		if !ok {
+			return invalidCredentials(c, db, user.Email, user.ID, audit.ReasonWrongPassword)
+		}
 
-		// compare the password
-		if user.Password != req.Password {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid password",
+		if err := ClearAccountLockoutInStore(db, user.Email); err != nil {
+			fmt.Println(err)
+		}
+		if user.Deactivated {
+			recordSignIn(db, c, user.Email, user.ID, 0, audit.ReasonDeactivated)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Account is deactivated",
 			})
 		}
 
-		// Generate JWT token
-		claims := jwt.MapClaims{
-			"user_id": user.ID,
-			"email":   user.Email,
-			"exp":     time.Now().Add(time.Hour * time.Duration(middleware.JWTExpirationHours)).Unix(),
+		// Accounts from before passwords were hashed are upgraded on sign in
+		if needsRehash {
+			if hashed, err := HashPassword(req.Password); err == nil {
+				if err := UpdatePasswordInStore(db, user.ID, hashed); err != nil {
+					fmt.Println(err)
+				}
+			}
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		t, err := token.SignedString([]byte(middleware.JWTSecret))
+		t, orgID, err := IssueToken(db, user.ID, user.Email, middleware.AuthMethodPassword)
 		if err != nil {
+			fmt.Println(err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to generate token",
+				"error": "Failed to sign in",
 			})
 		}
 
+		recordSignIn(db, c, user.Email, user.ID, orgID, "")
+
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"user_id": user.ID,
+			"org_id":  orgID,
 			"token":   t,
 			"message": "User signed in successfully",
 		})
 	}
 }
 
+// IssueToken signs an access token for a user who just authenticated with
+// method. The token is scoped to the first organization the user joined.
+func IssueToken(db *sql.DB, userID int, email, method string) (string, int, error) {
+	orgID, role, err := org.GetDefaultOrgForUser(db, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", 0, err
+	}
+
+	principal := middleware.Principal{
+		UserID:     userID,
+		Email:      email,
+		OrgID:      orgID,
+		AuthMethod: method,
+	}
+	if role != "" {
+		principal.Roles = []string{role}
+	}
+
+	t, err := middleware.GenerateToken(principal)
+	if err != nil {
+		return "", 0, err
+	}
+	return t, orgID, nil
+}
+
+// invalidCredentials counts a failed attempt towards lockout and answers
+// with the same error whether the email or the password was wrong.
+func invalidCredentials(c *fiber.Ctx, db *sql.DB, email string, userID int, reason string) error {
+	if err := RecordFailedSignInInStore(db, email, c.IP()); err != nil {
+		fmt.Println(err)
+	}
+	recordSignIn(db, c, email, userID, 0, reason)
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": "Invalid email or password",
+	})
+}
+
+// recordSignIn adds a password sign-in attempt to the audit trail. An empty
+// reason means it succeeded.
+func recordSignIn(db *sql.DB, c *fiber.Ctx, email string, userID, orgID int, reason string) {
+	audit.RecordAuthEvent(db, c, types.AuthEvent{
+		UserID:  userID,
+		OrgID:   orgID,
+		Email:   email,
+		Type:    audit.EventSignIn,
+		Success: reason == "",
+		Reason:  reason,
+	})
+}
+
+// FetchAllUsers returns the workspace-visible profile of every member of the
+// active organization. Emails are left out; see GetUser for the full record.
 func FetchAllUsers(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		users, err := GetAllUsers(db)
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionList, authz.Resource{Kind: authz.ResourceUser}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied",
+			})
+		}
+
+		users, err := ListMembersPublicFromStore(db, principal.OrgID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve users",
@@ -191,4 +374,50 @@ func FetchAllUsers(db *sql.DB) fiber.Handler {
 
 		return c.Status(fiber.StatusOK).JSON(users)
 	}
-}
\ No newline at end of file
+}
+
+// SearchUsers is a typeahead over the active members of the active
+// organization, e.g. for assignee pickers. Pass ?q= with the start of a name
+// and page with ?limit= and ?offset=.
+func SearchUsers(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := middleware.GetPrincipal(c)
+		if err != nil {
+			return middleware.Unauthorized(c)
+		}
+		if !authz.Can(principal, authz.ActionList, authz.Resource{Kind: authz.ResourceUser}) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Access denied",
+			})
+		}
+
+		limit := c.QueryInt("limit", defaultSearchLimit)
+		offset := c.QueryInt("offset", 0)
+		if limit < 1 || limit > maxSearchLimit || offset < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("limit must be between 1 and %d and offset not negative", maxSearchLimit),
+			})
+		}
+
+		// Ask for one extra row to learn whether there is another page
+		users, err := SearchUsersInStore(db, principal.OrgID, c.Query("q"), limit+1, offset)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to search users",
+			})
+		}
+
+		hasMore := len(users) > limit
+		if hasMore {
+			users = users[:limit]
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"users":    users,
+			"limit":    limit,
+			"offset":   offset,
+			"has_more": hasMore,
+		})
+	}
+}
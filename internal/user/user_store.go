@@ -3,24 +3,44 @@ package users
 import (
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
 
+	"github.com/adarsh-jaiss/zocket/internal/history"
 	"github.com/adarsh-jaiss/zocket/types"
+	"github.com/lib/pq"
 )
 
 var (
 	ErrEmailExists = errors.New("email already exists")
+	ErrSoleAdmin   = errors.New("user is the only admin of an organization with other members")
+
+	errPlaceholderEmailTaken = errors.New("deleted-user placeholder email belongs to another account")
 )
 
+// deletedUserEmail is the address of the placeholder account that inherits
+// the authored records of deleted users. The account itself is found by its
+// placeholder flag; nobody else can take the address since .invalid ones are
+// refused (see IsReservedEmail).
+const deletedUserEmail = "deleted-user@zocket.invalid"
+
+// IsReservedEmail reports whether email is on a domain that can't receive
+// mail and is kept for internal accounts.
+func IsReservedEmail(email string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSpace(email)), ".invalid")
+}
+
 func GetUserByEmailAndPassword(db *sql.DB, email string) (types.SignInRequest, error) {
 	var user types.SignInRequest
 	query := `
-		SELECT user_id, email, password
+		SELECT user_id, email, password, deactivated_at IS NOT NULL
 		FROM users WHERE email = $1
 	`
 	err := db.QueryRow(query, email).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Password,
+		&user.Deactivated,
 	)
 	if err != nil {
 		return types.SignInRequest{}, err
@@ -30,8 +50,11 @@ func GetUserByEmailAndPassword(db *sql.DB, email string) (types.SignInRequest, e
 
 func GetUserFromStore(db *sql.DB, userID int) (types.User, error) {
 	var user types.User
+	var deactivatedAt sql.NullTime
 	query := `
-		SELECT user_id, email, first_name, last_name, created_at, logged_in_at FROM users WHERE user_id = $1
+		SELECT user_id, email, first_name, last_name, created_at, logged_in_at,
+			email_verified, COALESCE(pending_email, ''), deactivated_at
+		FROM users WHERE user_id = $1
 	`
 	err := db.QueryRow(query, userID).Scan(
 		&user.ID,
@@ -40,10 +63,16 @@ func GetUserFromStore(db *sql.DB, userID int) (types.User, error) {
 		&user.LastName,
 		&user.CreatedAt,
 		&user.LoggedInAt,
+		&user.EmailVerified,
+		&user.PendingEmail,
+		&deactivatedAt,
 	)
 	if err != nil {
 		return types.User{}, err
 	}
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = deactivatedAt.Time.Format(time.RFC3339)
+	}
 	return user, nil
 }
 
@@ -56,8 +85,8 @@ func CreateUserInStore(db *sql.DB, user types.User) (int, error) {
 		return 0, err
 	}
 	query := `
-		INSERT INTO users (email, password, first_name, last_name, created_at, logged_in_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		INSERT INTO users (email, password, first_name, last_name, created_at, logged_in_at, email_verified)
+		VALUES ($1, $2, $3, $4, NOW(), NOW(), $5)
 		RETURNING user_id
 	`
 	var userID int
@@ -67,6 +96,7 @@ func CreateUserInStore(db *sql.DB, user types.User) (int, error) {
 		user.Password,
 		user.FirstName,
 		user.LastName,
+		user.EmailVerified,
 	).Scan(&userID)
 
 	if err != nil {
@@ -75,13 +105,16 @@ func CreateUserInStore(db *sql.DB, user types.User) (int, error) {
 	return userID, nil
 }
 
-func GetAllUsers(db *sql.DB) ([]types.User, error) {
+// GetAllUsers returns the members of an organization
+func GetAllUsers(db *sql.DB, orgID int) ([]types.User, error) {
 	var users []types.User
 	query := `
-		SELECT user_id, email, first_name, last_name
-		FROM users
+		SELECT u.user_id, u.email, u.first_name, u.last_name
+		FROM users u
+		JOIN org_members m ON m.user_id = u.user_id
+		WHERE m.org_id = $1
 	`
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -102,3 +135,438 @@ func GetAllUsers(db *sql.DB) ([]types.User, error) {
 	}
 	return users, nil
 }
+
+// ListMembersPublicFromStore returns the workspace-visible profile of every
+// member of an organization, deactivated and suspended ones included.
+func ListMembersPublicFromStore(db *sql.DB, orgID int) ([]types.PublicUser, error) {
+	query := `
+		SELECT u.user_id, u.first_name, u.last_name, m.role,
+			u.deactivated_at IS NOT NULL OR m.suspended_at IS NOT NULL
+		FROM users u
+		JOIN org_members m ON m.user_id = u.user_id
+		WHERE m.org_id = $1
+		ORDER BY u.first_name, u.last_name, u.user_id
+	`
+	rows, err := db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPublicUsers(rows)
+}
+
+// SearchUsersInStore finds active members of an organization whose first
+// name, last name or full name starts with q. It returns at most limit users
+// starting at offset.
+func SearchUsersInStore(db *sql.DB, orgID int, q string, limit, offset int) ([]types.PublicUser, error) {
+	pattern := likeEscaper.Replace(strings.TrimSpace(q)) + "%"
+	query := `
+		SELECT u.user_id, u.first_name, u.last_name, m.role, FALSE
+		FROM users u
+		JOIN org_members m ON m.user_id = u.user_id
+		WHERE m.org_id = $1
+			AND u.deactivated_at IS NULL
+			AND m.suspended_at IS NULL
+			AND (
+				u.first_name ILIKE $2
+				OR u.last_name ILIKE $2
+				OR (u.first_name || ' ' || u.last_name) ILIKE $2
+			)
+		ORDER BY u.first_name, u.last_name, u.user_id
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := db.Query(query, orgID, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPublicUsers(rows)
+}
+
+// likeEscaper makes user input match literally inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func scanPublicUsers(rows *sql.Rows) ([]types.PublicUser, error) {
+	users := []types.PublicUser{}
+	for rows.Next() {
+		var user types.PublicUser
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Role,
+			&user.Deactivated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func GetPasswordFromStore(db *sql.DB, userID int) (string, error) {
+	var password string
+	query := `SELECT password FROM users WHERE user_id = $1`
+	err := db.QueryRow(query, userID).Scan(&password)
+	return password, err
+}
+
+func UpdatePasswordInStore(db *sql.DB, userID int, hashedPassword string) error {
+	query := `UPDATE users SET password = $1 WHERE user_id = $2`
+	_, err := db.Exec(query, hashedPassword, userID)
+	return err
+}
+
+func UpdateNameInStore(db *sql.DB, userID int, firstName, lastName string) error {
+	query := `
+		UPDATE users
+		SET
+			first_name = COALESCE(NULLIF($1, ''), first_name),
+			last_name = COALESCE(NULLIF($2, ''), last_name)
+		WHERE user_id = $3
+	`
+	_, err := db.Exec(query, firstName, lastName, userID)
+	return err
+}
+
+// SetPendingEmailInStore records an email change that still has to be
+// verified. The current email keeps working until then.
+func SetPendingEmailInStore(db *sql.DB, userID int, email string) error {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 AND user_id <> $2)`
+	if err := db.QueryRow(query, email, userID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailExists
+	}
+
+	query = `UPDATE users SET pending_email = $1 WHERE user_id = $2`
+	_, err := db.Exec(query, email, userID)
+	return err
+}
+
+// ConfirmEmailInStore swaps in a verified pending email. It returns
+// sql.ErrNoRows when email is no longer the pending one.
+func ConfirmEmailInStore(db *sql.DB, userID int, email string) error {
+	query := `
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, email_verified = TRUE
+		WHERE user_id = $1 AND pending_email = $2
+	`
+	result, err := db.Exec(query, userID, email)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrEmailExists
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MarkEmailVerifiedInStore records that the user proved they own email, as
+// long as it is still their address.
+func MarkEmailVerifiedInStore(db *sql.DB, userID int, email string) error {
+	query := `UPDATE users SET email_verified = TRUE WHERE user_id = $1 AND email = $2`
+	_, err := db.Exec(query, userID, email)
+	return err
+}
+
+// IsOrgMemberInStore reports whether the user belongs to the organization,
+// including deactivated and suspended users.
+func IsOrgMemberInStore(db *sql.DB, orgID, userID int) (bool, error) {
+	var member bool
+	query := `SELECT EXISTS (SELECT 1 FROM org_members WHERE org_id = $1 AND user_id = $2)`
+	err := db.QueryRow(query, orgID, userID).Scan(&member)
+	return member, err
+}
+
+// DeactivateUserInStore blocks the user from signing in and hands their open
+// tasks to reassignTo where that user is a member of the task's organization.
+// Everywhere else the tasks are unassigned. The handovers are logged in task
+// history as made by actorID. It returns the number of tasks that changed
+// hands.
+func DeactivateUserInStore(db *sql.DB, actorID, userID, reassignTo int) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET deactivated_at = NOW() WHERE user_id = $1 AND deactivated_at IS NULL`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE tasks t
+		SET
+			assigned_to = (
+				SELECT m.user_id FROM org_members m
+				JOIN users u ON u.user_id = m.user_id AND u.deactivated_at IS NULL
+				WHERE m.org_id = t.org_id AND m.user_id = NULLIF($2, 0) AND m.suspended_at IS NULL
+			),
+			updated_at = NOW()
+		WHERE t.assigned_to = $1 AND t.status <> 'Done'
+		RETURNING t.task_id, COALESCE(t.assigned_to, 0)
+	`
+	moved, err := releaseTasks(tx, actorID, userID, query, userID, reassignTo)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// ClaimAccountInStore hands an account whose email was never verified to
+// whoever just proved they own the address: the password is replaced,
+// pending email changes dropped and every token issued so far revoked.
+func ClaimAccountInStore(db *sql.DB, userID int, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET
+			password = $2,
+			email_verified = TRUE,
+			pending_email = NULL,
+			sessions_revoked_at = date_trunc('second', NOW())
+		WHERE user_id = $1
+	`
+	_, err := db.Exec(query, userID, hashedPassword)
+	return err
+}
+
+// SuspendMemberInStore takes away a user's access to one organization and
+// hands their open tasks in it to reassignTo if that user is an active
+// member, or unassigns them. The account and other memberships are left
+// alone. The handovers are logged in task history as made by actorID. It
+// returns the number of tasks that changed hands.
+func SuspendMemberInStore(db *sql.DB, actorID, orgID, userID, reassignTo int) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE org_members SET suspended_at = NOW()
+		WHERE org_id = $1 AND user_id = $2 AND suspended_at IS NULL
+	`
+	if _, err := tx.Exec(query, orgID, userID); err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE tasks t
+		SET
+			assigned_to = (
+				SELECT m.user_id FROM org_members m
+				JOIN users u ON u.user_id = m.user_id AND u.deactivated_at IS NULL
+				WHERE m.org_id = t.org_id AND m.user_id = NULLIF($3, 0) AND m.suspended_at IS NULL
+			),
+			updated_at = NOW()
+		WHERE t.org_id = $1 AND t.assigned_to = $2 AND t.status <> 'Done'
+		RETURNING t.task_id, COALESCE(t.assigned_to, 0)
+	`
+	moved, err := releaseTasks(tx, actorID, userID, query, orgID, userID, reassignTo)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// releaseTasks runs query, an update of tasks assigned to userID that returns
+// their ID and new assignee, and logs each handover in task history as made
+// by actorID. It returns the number of tasks that changed hands.
+func releaseTasks(tx *sql.Tx, actorID, userID int, query string, args ...interface{}) (int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	type handover struct{ taskID, assignee int }
+	var handovers []handover
+	for rows.Next() {
+		var h handover
+		if err := rows.Scan(&h.taskID, &h.assignee); err != nil {
+			return 0, err
+		}
+		handovers = append(handovers, h)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for _, h := range handovers {
+		before := history.TaskState{Assignee: userID}
+		after := history.TaskState{Assignee: h.assignee}
+		if err := history.RecordTaskChangesInStore(tx, h.taskID, actorID, before, after); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(handovers)), nil
+}
+
+// ReactivateMemberInStore gives a suspended user access to the organization
+// again. The deleted-user placeholder is never reactivated.
+func ReactivateMemberInStore(db *sql.DB, orgID, userID int) error {
+	query := `
+		UPDATE org_members SET suspended_at = NULL
+		WHERE org_id = $1 AND user_id = $2
+			AND user_id NOT IN (SELECT user_id FROM users WHERE placeholder)
+	`
+	_, err := db.Exec(query, orgID, userID)
+	return err
+}
+
+// HasOtherOrgsInStore reports whether the user belongs to any organization
+// besides orgID.
+func HasOtherOrgsInStore(db *sql.DB, orgID, userID int) (bool, error) {
+	var other bool
+	query := `SELECT EXISTS (SELECT 1 FROM org_members WHERE user_id = $1 AND org_id <> $2)`
+	err := db.QueryRow(query, userID, orgID).Scan(&other)
+	return other, err
+}
+
+// DeleteUserFromStore permanently removes a user. Tasks, suggestions,
+// projects and organizations they authored are handed to a shared
+// placeholder account so foreign keys stay intact and no personal data
+// remains. Users who are the last admin of a shared organization have to
+// hand it over first.
+func DeleteUserFromStore(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var soleAdmin bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM org_members m
+			WHERE m.user_id = $1 AND m.role = 'admin'
+				AND NOT EXISTS (
+					SELECT 1 FROM org_members o
+					WHERE o.org_id = m.org_id AND o.user_id <> $1 AND o.role = 'admin'
+				)
+				AND EXISTS (
+					SELECT 1 FROM org_members o
+					WHERE o.org_id = m.org_id AND o.user_id <> $1
+				)
+		)
+	`
+	if err := tx.QueryRow(query, userID).Scan(&soleAdmin); err != nil {
+		return err
+	}
+	if soleAdmin {
+		return ErrSoleAdmin
+	}
+
+	// The placeholder can never sign in: CheckPassword rejects its password
+	// and it is deactivated. A real account that registered its address
+	// before it was reserved is never adopted.
+	query = `
+		INSERT INTO users (email, password, first_name, last_name, created_at, logged_in_at, deactivated_at, placeholder)
+		VALUES ($1, $2, 'Deleted', 'User', NOW(), NOW(), NOW(), TRUE)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.Exec(query, deletedUserEmail, disabledPassword); err != nil {
+		return err
+	}
+	var placeholderID int
+	query = `SELECT user_id FROM users WHERE placeholder`
+	if err := tx.QueryRow(query).Scan(&placeholderID); err != nil {
+		if err == sql.ErrNoRows {
+			return errPlaceholderEmailTaken
+		}
+		return err
+	}
+
+	// Unassigning is logged before the user's history moves to the placeholder
+	query = `
+		UPDATE tasks SET assigned_to = NULL, updated_at = NOW()
+		WHERE assigned_to = $1
+		RETURNING task_id, 0
+	`
+	if _, err := releaseTasks(tx, userID, userID, query, userID); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`UPDATE tasks SET created_by = $2 WHERE created_by = $1`,
+		`UPDATE task_suggestions SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE task_recommendations SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE task_updates SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE ai_usage SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE projects SET owner_id = $2 WHERE owner_id = $1`,
+		`UPDATE organizations SET created_by = $2 WHERE created_by = $1`,
+		`UPDATE invitations SET invited_by = $2 WHERE invited_by = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, userID, placeholderID); err != nil {
+			return err
+		}
+	}
+
+	// Personal workspaces nobody else uses go away with their owner. Their
+	// suggestions are deleted first because databases created before
+	// task_suggestions cascaded would otherwise block the delete.
+	query = `
+		DELETE FROM task_suggestions s
+		USING tasks t, organizations o
+		WHERE s.task_id = t.task_id AND t.org_id = o.org_id
+			AND o.created_by = $2
+			AND EXISTS (SELECT 1 FROM org_members m WHERE m.org_id = o.org_id AND m.user_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM org_members m WHERE m.org_id = o.org_id AND m.user_id <> $1)
+	`
+	if _, err := tx.Exec(query, userID, placeholderID); err != nil {
+		return err
+	}
+	query = `
+		DELETE FROM organizations o
+		WHERE o.created_by = $2
+			AND EXISTS (SELECT 1 FROM org_members m WHERE m.org_id = o.org_id AND m.user_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM org_members m WHERE m.org_id = o.org_id AND m.user_id <> $1)
+	`
+	if _, err := tx.Exec(query, userID, placeholderID); err != nil {
+		return err
+	}
+
+	// Sign-in history holds IPs and user agents, so it goes too
+	query = `DELETE FROM auth_events WHERE user_id = $1`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return err
+	}
+	query = `
+		DELETE FROM login_attempts
+		WHERE scope = $2 AND key = (SELECT LOWER(email) FROM users WHERE user_id = $1)
+	`
+	if _, err := tx.Exec(query, userID, lockScopeAccount); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM users WHERE user_id = $1`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDB connects to the database named by TEST_DATABASE_URL, which must
+// already have the schema (see scripts/script.go). Tests that need it are
+// skipped without one.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDeleteUserWithAnalyzedTasks(t *testing.T) {
+	db := testDB(t)
+
+	var userID, orgID, taskID int
+	email := fmt.Sprintf("delete-me-%d@example.com", time.Now().UnixNano())
+	query := `
+		INSERT INTO users (email, password, first_name, last_name)
+		VALUES ($1, 'x', 'Delete', 'Me')
+		RETURNING user_id
+	`
+	if err := db.QueryRow(query, email).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	query = `INSERT INTO organizations (name, created_by) VALUES ('Personal', $1) RETURNING org_id`
+	if err := db.QueryRow(query, userID).Scan(&orgID); err != nil {
+		t.Fatal(err)
+	}
+	query = `INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, 'admin')`
+	if _, err := db.Exec(query, orgID, userID); err != nil {
+		t.Fatal(err)
+	}
+	query = `INSERT INTO tasks (org_id, title, created_by) VALUES ($1, 'Analyzed task', $2) RETURNING task_id`
+	if err := db.QueryRow(query, orgID, userID).Scan(&taskID); err != nil {
+		t.Fatal(err)
+	}
+	query = `
+		INSERT INTO task_suggestions (task_id, user_id, suggestion_text, sub_tasks)
+		VALUES ($1, $2, 'Split it up', '[]')
+	`
+	if _, err := db.Exec(query, taskID, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteUserFromStore(db, userID); err != nil {
+		t.Fatalf("DeleteUserFromStore() = %v", err)
+	}
+
+	counts := []struct {
+		name  string
+		query string
+		arg   int
+	}{
+		{"users", `SELECT COUNT(*) FROM users WHERE user_id = $1`, userID},
+		{"organizations", `SELECT COUNT(*) FROM organizations WHERE org_id = $1`, orgID},
+		{"tasks", `SELECT COUNT(*) FROM tasks WHERE task_id = $1`, taskID},
+		{"task_suggestions", `SELECT COUNT(*) FROM task_suggestions WHERE task_id = $1`, taskID},
+	}
+	for _, c := range counts {
+		var n int
+		if err := db.QueryRow(c.query, c.arg).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s, want 0", n, c.name)
+		}
+	}
+}
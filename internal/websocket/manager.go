@@ -1,8 +1,10 @@
 package websocket
 
 import (
+	"encoding/json"
 	"sync"
 
+	"github.com/adarsh-jaiss/zocket/internal/middleware"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
@@ -10,11 +12,33 @@ import (
 type Client struct {
 	Conn   *websocket.Conn
 	UserID int
+	OrgID  int
+
+	// projects the client subscribed to. A client without subscriptions
+	// receives events for every project in its organization.
+	projects map[int]bool
+}
+
+// message is a broadcast payload. An orgID of 0 reaches every client and a
+// projectID of 0 every client in the organization. A userID other than 0
+// limits it to that user's connections.
+type message struct {
+	orgID     int
+	projectID int
+	userID    int
+	data      []byte
+}
+
+// ClientMessage is sent by clients to manage their project subscriptions:
+// {"action": "subscribe", "project_id": 1}
+type ClientMessage struct {
+	Action    string `json:"action"`
+	ProjectID int    `json:"project_id"`
 }
 
 type Manager struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan message
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
@@ -23,7 +47,7 @@ type Manager struct {
 func NewManager() *Manager {
 	return &Manager{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan message),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 	}
@@ -56,31 +80,86 @@ func (m *Manager) Run() {
 			}
 			m.mutex.Unlock()
 
-		case message := <-m.broadcast:
-			m.mutex.RLock()
+		case msg := <-m.broadcast:
+			m.mutex.Lock()
 			for client := range m.clients {
-				if err := client.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				if !client.wants(msg) {
+					continue
+				}
+				if err := client.Conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
 					client.Conn.Close()
 					delete(m.clients, client)
 				}
 			}
-			m.mutex.RUnlock()
+			m.mutex.Unlock()
 		}
 	}
 }
 
-func (m *Manager) BroadcastToAll(message []byte) {
-	m.broadcast <- message
+func (m *Manager) BroadcastToAll(data []byte) {
+	m.broadcast <- message{data: data}
+}
+
+// BroadcastToOrg sends data only to clients connected with orgID active.
+func (m *Manager) BroadcastToOrg(orgID int, data []byte) {
+	m.broadcast <- message{orgID: orgID, data: data}
+}
+
+// BroadcastToProject sends data to clients of orgID that are subscribed to
+// projectID or have no subscriptions at all.
+func (m *Manager) BroadcastToProject(orgID, projectID int, data []byte) {
+	m.broadcast <- message{orgID: orgID, projectID: projectID, data: data}
+}
+
+// SendToUser sends data to every connection of userID, whichever
+// organization is active on it.
+func (m *Manager) SendToUser(userID int, data []byte) {
+	m.broadcast <- message{userID: userID, data: data}
+}
+
+func (m *Manager) subscribe(client *Client, projectID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if client.projects == nil {
+		client.projects = make(map[int]bool)
+	}
+	client.projects[projectID] = true
+}
+
+func (m *Manager) unsubscribe(client *Client, projectID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(client.projects, projectID)
+}
+
+// wants reports whether the client should receive msg. Callers hold the
+// manager mutex.
+func (c *Client) wants(msg message) bool {
+	if msg.userID != 0 {
+		return c.UserID == msg.userID
+	}
+	if msg.orgID != 0 && c.OrgID != msg.orgID {
+		return false
+	}
+	if msg.projectID == 0 || len(c.projects) == 0 {
+		return true
+	}
+	return c.projects[msg.projectID]
 }
 
 func WebsocketHandler() fiber.Handler {
 	return websocket.New(func(c *websocket.Conn) {
-		// Get user ID from context (set by JWT middleware)
-		userID := c.Locals("user_id").(int)
+		// Get the principal from context (set by JWT middleware)
+		principal, ok := c.Locals(middleware.PrincipalKey).(middleware.Principal)
+		if !ok {
+			c.Close()
+			return
+		}
 
 		client := &Client{
 			Conn:   c,
-			UserID: userID,
+			UserID: principal.UserID,
+			OrgID:  principal.OrgID,
 		}
 
 		manager.register <- client
@@ -93,8 +172,21 @@ func WebsocketHandler() fiber.Handler {
 			if err != nil {
 				break
 			}
-			// Echo the message back (optional)
-			manager.broadcast <- msg
+
+			var clientMsg ClientMessage
+			if err := json.Unmarshal(msg, &clientMsg); err == nil && clientMsg.ProjectID != 0 {
+				switch clientMsg.Action {
+				case "subscribe":
+					manager.subscribe(client, clientMsg.ProjectID)
+					continue
+				case "unsubscribe":
+					manager.unsubscribe(client, clientMsg.ProjectID)
+					continue
+				}
+			}
+
+			// Echo the message back to the sender's organization (optional)
+			manager.BroadcastToOrg(client.OrgID, msg)
 		}
 	})
 }
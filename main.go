@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/adarsh-jaiss/zocket/db"
+	"github.com/adarsh-jaiss/zocket/internal/ai"
+	"github.com/adarsh-jaiss/zocket/internal/audit"
+	"github.com/adarsh-jaiss/zocket/internal/authz"
+	"github.com/adarsh-jaiss/zocket/internal/digest"
+	"github.com/adarsh-jaiss/zocket/internal/invitations"
+	"github.com/adarsh-jaiss/zocket/internal/jobs"
 	"github.com/adarsh-jaiss/zocket/internal/middleware"
+	"github.com/adarsh-jaiss/zocket/internal/notifier"
+	"github.com/adarsh-jaiss/zocket/internal/oidc"
+	"github.com/adarsh-jaiss/zocket/internal/org"
+	"github.com/adarsh-jaiss/zocket/internal/projects"
+	"github.com/adarsh-jaiss/zocket/internal/ratelimit"
 	tasks "github.com/adarsh-jaiss/zocket/internal/tasks"
 	users "github.com/adarsh-jaiss/zocket/internal/user"
 	wsmanager "github.com/adarsh-jaiss/zocket/internal/websocket"
@@ -35,12 +47,33 @@ func main() {
 	// Initialize WebSocket manager
 	wsmanager.InitManager()
 
+	// Initialize notifier for messages sent outside the app (invites, digests)
+	notifier.InitNotifier()
+
+	// Initialize rate limit buckets, in memory or shared through Postgres
+	ratelimit.InitStore(conn)
+
+	// Initialize single sign-on; disabled unless OIDC_ISSUER is set
+	oidc.InitProvider()
+
+	// Initialize the embedder used to find similar tasks
+	ai.InitEmbedder()
+
+	// Start background workers for queued jobs such as task analysis
+	tasks.RegisterJobs()
+	digest.RegisterJobs()
+	jobs.StartWorkers(context.Background(), conn)
+
+	// Queue daily project digests; disabled with DIGEST_HOUR=off
+	digest.StartScheduler(context.Background(), conn)
+
 	app := fiber.New()
 	app.Use(logger.New()) // Add logging middleware
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-		AllowMethods: "GET, HEAD, PUT, PATCH, POST, DELETE",
+		AllowOrigins:  "*",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, " + org.OrgHeader,
+		ExposeHeaders: "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After",
+		AllowMethods:  "GET, HEAD, PUT, PATCH, POST, DELETE",
 	}))
 
 	// WebSocket middleware
@@ -55,29 +88,99 @@ func main() {
 	api := app.Group("/api")
 
 	// public routes (auth)
-	auth := api.Group("/auth")
+	auth := api.Group("/auth", ratelimit.New(ratelimit.GroupAuth))
 	auth.Post("/signup", users.Signup(conn))
 	auth.Post("/signin", users.SignIn(conn))
+	auth.Post("/verify-email", users.VerifyEmail(conn))
+	auth.Get("/oidc/login", oidc.Login())
+	auth.Get("/oidc/callback", oidc.Callback(conn))
 
 	// v1 (protected routes)
-	v1 := api.Group("/v1", middleware.JWTProtected())
+	v1 := api.Group("/v1", middleware.JWTProtected(), org.ResolveActiveOrg(conn), ratelimit.New(ratelimit.GroupWrite))
 
 	// WebSocket endpoint
 	v1.Get("/ws", wsmanager.WebsocketHandler())
 
 	// user routes
 	user := v1.Group("/user")
+	user.Get("/me", users.GetMe(conn))
+	user.Patch("/me", users.UpdateMe(conn))
+	user.Put("/me/password", users.ChangePassword(conn))
+	user.Post("/me/deactivate", users.DeactivateMe(conn))
+	user.Delete("/me", users.DeleteMe(conn))
+	user.Get("/me/sessions", audit.ListMySessions(conn))
+	user.Get("/search", users.SearchUsers(conn))
 	user.Get("/:id", users.GetUser(conn))
-	user.Get("",users.FetchAllUsers(conn))
+	user.Get("", users.FetchAllUsers(conn))
+	user.Get("/:id/role", authz.GetUserRole(conn))
+	user.Put("/:id/role", authz.SetUserRole(conn))
+	user.Post("/:id/deactivate", users.DeactivateUser(conn))
+	user.Post("/:id/reactivate", users.ReactivateUser(conn))
+	user.Post("/:id/unlock", users.UnlockUser(conn))
+
+	// AI usage routes
+	v1.Get("/ai/usage", ai.GetUsage(conn))
+	v1.Put("/ai/quota", ai.SetQuota(conn))
+	v1.Get("/ai/prompts", ai.ListPrompts(conn))
+	v1.Put("/ai/prompts/:feature", ai.SetPromptVersion(conn))
+	v1.Post("/ai/prompts/:feature/preview", tasks.PreviewPrompt(conn))
+	v1.Get("/ai/redaction-rules", ai.ListRedactionRules(conn))
+	v1.Post("/ai/redaction-rules", ai.CreateRedactionRule(conn))
+	v1.Delete("/ai/redaction-rules/:ruleId", ai.DeleteRedactionRule(conn))
+
+	// audit routes
+	v1.Get("/audit/auth", audit.ListAuthEvents(conn))
+
+	// role routes
+	v1.Get("/roles", authz.ListRoles(conn))
+
+	// organization routes
+	orgs := v1.Group("/orgs")
+	orgs.Get("", org.ListOrgs(conn))
+	orgs.Post("", org.CreateOrg(conn))
+	orgs.Get("/current", org.GetCurrentOrg(conn))
+	orgs.Get("/current/members", org.ListMembers(conn))
+	// Members are added by invitation so they have to accept first
+	orgs.Post("/current/members", invitations.CreateInvitation(conn))
+	orgs.Delete("/current/members/:userId", org.RemoveMember(conn))
+	orgs.Post("/:id/switch", org.SwitchOrg(conn))
 
 	// task routes
 	tasksGroup := v1.Group("/tasks")
-	tasksGroup.Post("/", tasks.CreateTask(conn))
+	// ?recommend=true may ask the AI model, so it counts against the AI budget
+	tasksGroup.Post("/", ratelimit.When(tasks.WantsRecommendation, ratelimit.GroupAI), tasks.CreateTask(conn))
+	tasksGroup.Post("/parse", ratelimit.New(ratelimit.GroupAI), tasks.ParseTask(conn))
 	tasksGroup.Get("/", tasks.ListTasks(conn))
 	tasksGroup.Get("/:id", tasks.GetTask(conn))
 	tasksGroup.Put("/:id", tasks.UpdateTask(conn))
 	tasksGroup.Delete("/:id", tasks.DeleteTask(conn))
-	tasksGroup.Post("/:id/analyze", tasks.AnalyzeTask(conn))
+	tasksGroup.Post("/:id/analyze", ratelimit.New(ratelimit.GroupAI), tasks.AnalyzeTask(conn))
+	tasksGroup.Post("/:id/recommend", ratelimit.New(ratelimit.GroupAI), tasks.RecommendTask(conn))
+	tasksGroup.Post("/:id/estimate", ratelimit.New(ratelimit.GroupAI), tasks.EstimateTask(conn))
+	tasksGroup.Get("/:id/recommendations", tasks.ListRecommendations(conn))
+	tasksGroup.Get("/:id/similar", ratelimit.When(tasks.UsesRemoteEmbedder, ratelimit.GroupAI), tasks.SimilarTasks(conn))
+	tasksGroup.Post("/:id/recommendations/:recommendationId/accept", tasks.AcceptRecommendation(conn))
+
+	// job routes
+	v1.Get("/jobs/:id", jobs.GetJob(conn))
+
+	// invitation routes
+	invitationsGroup := v1.Group("/invitations")
+	invitationsGroup.Post("/", invitations.CreateInvitation(conn))
+	invitationsGroup.Get("/", invitations.ListInvitations(conn))
+	invitationsGroup.Post("/accept", invitations.AcceptInvitationHandler(conn))
+	invitationsGroup.Post("/:id/resend", invitations.ResendInvitation(conn))
+	invitationsGroup.Delete("/:id", invitations.RevokeInvitation(conn))
+
+	// project routes
+	projectsGroup := v1.Group("/projects")
+	projectsGroup.Post("/", projects.CreateProject(conn))
+	projectsGroup.Get("/", projects.ListProjects(conn))
+	projectsGroup.Get("/:id", projects.GetProject(conn))
+	projectsGroup.Put("/:id", projects.UpdateProject(conn))
+	projectsGroup.Delete("/:id", projects.DeleteProject(conn))
+	projectsGroup.Get("/:id/tasks", tasks.ListProjectTasks(conn))
+	projectsGroup.Get("/:id/summary", ratelimit.New(ratelimit.GroupAI), digest.ProjectSummary(conn))
 
 	log.Fatal(app.Listen(":8000"))
 }
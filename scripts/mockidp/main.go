@@ -0,0 +1,238 @@
+// Command mockidp is a minimal OpenID Connect provider for trying out and
+// testing single sign-on locally. It signs in every visitor as the user
+// given by MOCK_IDP_EMAIL (or the login_hint parameter) without asking for
+// credentials. Never expose it outside a development machine.
+//
+// Point the API at it with:
+//
+//	OIDC_ISSUER=http://localhost:9000
+//	OIDC_CLIENT_ID=zocket
+//	OIDC_CLIENT_SECRET=secret
+//	OIDC_REDIRECT_URL=http://localhost:8000/api/auth/oidc/callback
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "mockidp-1"
+
+// authRequest is what /authorize remembers about a code until /token
+// redeems it.
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	challenge     string
+	email         string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type server struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+func main() {
+	addr := getenv("MOCK_IDP_ADDR", ":9000")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &server{
+		issuer:       getenv("MOCK_IDP_ISSUER", "http://localhost"+addr),
+		clientID:     getenv("MOCK_IDP_CLIENT_ID", "zocket"),
+		clientSecret: getenv("MOCK_IDP_CLIENT_SECRET", "secret"),
+		key:          key,
+		codes:        make(map[string]authRequest),
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	http.HandleFunc("/jwks", s.jwks)
+	http.HandleFunc("/authorize", s.authorize)
+	http.HandleFunc("/token", s.token)
+
+	fmt.Printf("mock OIDC provider listening on %s, issuer %s\n", addr, s.issuer)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize approves every request for the configured user and redirects
+// straight back with a code.
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.clientID || q.Get("response_type") != "code" {
+		http.Error(w, "unknown client or unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	email := q.Get("login_hint")
+	if email == "" {
+		email = getenv("MOCK_IDP_EMAIL", "dev@example.com")
+	}
+
+	code := randomHex()
+	s.mu.Lock()
+	s.codes[code] = authRequest{
+		clientID:      s.clientID,
+		redirectURI:   redirectURI.String(),
+		nonce:         q.Get("nonce"),
+		challenge:     q.Get("code_challenge"),
+		email:         email,
+		emailVerified: os.Getenv("MOCK_IDP_UNVERIFIED") == "",
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || clientSecret != s.clientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	req, found := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if !found || time.Now().After(req.expiresAt) ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != req.redirectURI {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	name := strings.SplitN(req.email, "@", 2)[0]
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            "mock|" + req.email,
+		"aud":            req.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          req.nonce,
+		"email":          req.email,
+		"email_verified": req.emailVerified,
+		"given_name":     name,
+		"family_name":    "Mock",
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomHex(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomHex() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func getenv(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
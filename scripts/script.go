@@ -27,7 +27,7 @@ func main() {
 	fmt.Println("Dropping tables...")
 
 	// Drop tables in reverse order of dependencies
-	tables := []string{"task_suggestions", "task_updates", "tasks", "users"}
+	tables := []string{"jobs", "digest_runs", "project_summaries", "task_recommendations", "task_embeddings", "analysis_cache", "ai_redaction_rules", "ai_prompt_versions", "ai_quotas", "ai_usage", "task_suggestions", "task_updates", "tasks", "invitations", "projects", "rate_limits", "login_attempts", "auth_events", "user_identities", "org_members", "organizations", "roles", "users"}
 	for _, table := range tables {
 		fmt.Printf("dropping %v table\n", table)
 		if table == "tasks" {
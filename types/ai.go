@@ -0,0 +1,65 @@
+package types
+
+// AIUsage is the accounting record of one model call.
+type AIUsage struct {
+	UsageID          int64   `json:"id" db:"usage_id"`
+	OrgID            int     `json:"org_id" db:"org_id"`
+	UserID           int     `json:"user_id" db:"user_id"`
+	TaskID           int     `json:"task_id,omitempty" db:"task_id"`
+	Feature          string  `json:"feature" db:"feature"`
+	Model            string  `json:"model" db:"model"`
+	PromptTokens     int     `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens" db:"completion_tokens"`
+	LatencyMS        int     `json:"latency_ms" db:"latency_ms"`
+	CostUSD          float64 `json:"cost_usd" db:"cost_usd"`
+	Success          bool    `json:"success" db:"success"`
+	Redactions       int     `json:"redactions" db:"redactions"`
+	CreatedAt        string  `json:"created_at" db:"created_at"`
+}
+
+// AIUsageTotals sums up AI usage over a period.
+type AIUsageTotals struct {
+	UserID           int     `json:"user_id,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// AIQuota caps the tokens an organization and each of its members may use
+// per calendar month. Zero means unlimited.
+type AIQuota struct {
+	MonthlyTokensPerUser int `json:"monthly_tokens_per_user" db:"monthly_tokens_per_user"`
+	MonthlyTokensPerOrg  int `json:"monthly_tokens_per_org" db:"monthly_tokens_per_org"`
+}
+
+// AIPromptVersion is the prompt version an organization uses for an AI
+// feature, along with the versions it can choose from.
+type AIPromptVersion struct {
+	Feature        string   `json:"feature" db:"feature"`
+	Version        string   `json:"version" db:"version"`
+	DefaultVersion string   `json:"default_version"`
+	Versions       []string `json:"versions"`
+}
+
+// PromptPreviewRequest asks for a prompt rendered for a task. Version
+// defaults to the organization's selection; Description and Context are
+// the same extras an analysis request can carry.
+type PromptPreviewRequest struct {
+	TaskID      int    `json:"task_id"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context,omitempty"`
+}
+
+// RedactionRule is an organization's own pattern for values that must not
+// be sent to a model, on top of the built-in detectors.
+type RedactionRule struct {
+	RuleID    int    `json:"id" db:"rule_id"`
+	OrgID     int    `json:"org_id" db:"org_id"`
+	Name      string `json:"name" db:"name"`
+	Pattern   string `json:"pattern" db:"pattern"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+}
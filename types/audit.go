@@ -0,0 +1,33 @@
+package types
+
+import "time"
+
+// AuthEvent is one entry of the authentication audit trail: a sign-in,
+// sign-up or failed attempt.
+type AuthEvent struct {
+	EventID   int64  `json:"id" db:"event_id"`
+	UserID    int    `json:"user_id,omitempty" db:"user_id"`
+	OrgID     int    `json:"org_id,omitempty" db:"org_id"`
+	Email     string `json:"email" db:"email"`
+	Type      string `json:"type" db:"event_type"`
+	Method    string `json:"method" db:"method"`
+	Success   bool   `json:"success" db:"success"`
+	Reason    string `json:"reason,omitempty" db:"reason"`
+	IP        string `json:"ip" db:"ip"`
+	UserAgent string `json:"user_agent" db:"user_agent"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+}
+
+// AuthEventFilter narrows down the audit trail. Zero values match
+// everything.
+type AuthEventFilter struct {
+	UserID  int
+	Email   string
+	IP      string
+	Type    string
+	Success *bool
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Offset  int
+}
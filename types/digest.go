@@ -0,0 +1,58 @@
+package types
+
+// TaskRef is a short reference to a task in a summary.
+type TaskRef struct {
+	TaskID   int        `json:"id"`
+	Key      string     `json:"key,omitempty"`
+	Title    string     `json:"title"`
+	Status   TaskStatus `json:"status,omitempty"`
+	Assignee string     `json:"assignee,omitempty"`
+	DueDate  string     `json:"due_date,omitempty"`
+}
+
+// Workload is the number of unfinished tasks assigned to a member across
+// the organization.
+type Workload struct {
+	UserID    int    `json:"user_id"`
+	Name      string `json:"name"`
+	OpenTasks int    `json:"open_tasks"`
+}
+
+// TaskChange is one entry of a task's history: who changed which field
+// from what to what, and when. Assignees are given by name.
+type TaskChange struct {
+	TaskID int        `json:"id"`
+	Key    string     `json:"key,omitempty"`
+	Title  string     `json:"title"`
+	Field  ChangeType `json:"field"`
+	From   string     `json:"from,omitempty"`
+	To     string     `json:"to,omitempty"`
+	By     string     `json:"by"`
+	At     string     `json:"at"`
+}
+
+// ProjectFacts is what happened in a project over a number of days, the
+// input to its summary.
+type ProjectFacts struct {
+	Days       int        `json:"days"`
+	Created    int        `json:"created"`
+	Changes    int        `json:"changes"`
+	Done       []TaskRef  `json:"done"`
+	Blocked    []TaskRef  `json:"blocked"`
+	Overdue    []TaskRef  `json:"overdue"`
+	Overloaded []Workload `json:"overloaded"`
+	// History holds the most recent of the changes, newest first
+	History []TaskChange `json:"history"`
+}
+
+// ProjectSummary is a short written status of a project.
+type ProjectSummary struct {
+	ProjectID int          `json:"project_id"`
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Summary   string       `json:"summary"`
+	Source    string       `json:"source"`
+	Cached    bool         `json:"cached"`
+	Facts     ProjectFacts `json:"facts"`
+	CreatedAt string       `json:"created_at"`
+}
@@ -0,0 +1,38 @@
+package types
+
+// EffortEstimate is a proposed effort for a piece of work.
+type EffortEstimate struct {
+	Points     float64 `json:"points"`
+	Hours      float64 `json:"hours"`
+	Confidence float64 `json:"confidence"`
+	Rationale  string  `json:"rationale"`
+}
+
+// SubTaskEstimate is the proposed effort for a subtask suggested by an
+// analysis.
+type SubTaskEstimate struct {
+	Title      string `json:"title"`
+	Complexity string `json:"complexity,omitempty"`
+	EffortEstimate
+}
+
+// EstimateCalibration describes the completed tasks an estimate was
+// calibrated against. HoursPerPoint is the team's median working hours
+// per story point and OverrunRatio the median of actual over estimated
+// hours; either is 0 when there were too few tasks to tell.
+type EstimateCalibration struct {
+	Samples       int     `json:"samples"`
+	HoursPerPoint float64 `json:"hours_per_point,omitempty"`
+	OverrunRatio  float64 `json:"overrun_ratio,omitempty"`
+}
+
+// TaskEstimate is the response of the effort estimator. Task is the
+// updated task when the estimate was applied to it.
+type TaskEstimate struct {
+	TaskID      int                 `json:"task_id"`
+	Estimate    EffortEstimate      `json:"estimate"`
+	SubTasks    []SubTaskEstimate   `json:"sub_tasks"`
+	Calibration EstimateCalibration `json:"calibration"`
+	Applied     bool                `json:"applied"`
+	Task        *Task               `json:"task,omitempty"`
+}
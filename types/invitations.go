@@ -0,0 +1,25 @@
+package types
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationRevoked  InvitationStatus = "revoked"
+	InvitationExpired  InvitationStatus = "expired"
+)
+
+type Invitation struct {
+	InvitationID int              `json:"id" db:"invitation_id"`
+	OrgID        int              `json:"org_id" db:"org_id"`
+	ProjectID    int              `json:"project_id,omitempty" db:"project_id"`
+	Email        string           `json:"email" db:"email"`
+	Role         string           `json:"role" db:"role"`
+	InvitedBy    int              `json:"invited_by" db:"invited_by"`
+	Status       InvitationStatus `json:"status"`
+	SentCount    int              `json:"sent_count" db:"sent_count"`
+	ExpiresAt    string           `json:"expires_at" db:"expires_at"`
+	AcceptedAt   string           `json:"accepted_at,omitempty" db:"accepted_at"`
+	RevokedAt    string           `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt    string           `json:"created_at" db:"created_at"`
+}
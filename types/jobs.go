@@ -0,0 +1,31 @@
+package types
+
+import "encoding/json"
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a unit of background work, such as an AI analysis.
+type Job struct {
+	JobID       int64           `json:"id" db:"job_id"`
+	Kind        string          `json:"kind" db:"kind"`
+	OrgID       int             `json:"org_id" db:"org_id"`
+	UserID      int             `json:"user_id" db:"user_id"`
+	TaskID      int             `json:"task_id,omitempty" db:"task_id"`
+	Payload     json.RawMessage `json:"-" db:"payload"`
+	Status      JobStatus       `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"max_attempts" db:"max_attempts"`
+	RunAt       string          `json:"run_at" db:"run_at"`
+	LastError   string          `json:"last_error,omitempty" db:"last_error"`
+	Result      json.RawMessage `json:"result,omitempty" db:"result"`
+	CreatedAt   string          `json:"created_at" db:"created_at"`
+	UpdatedAt   string          `json:"updated_at" db:"updated_at"`
+	FinishedAt  string          `json:"finished_at,omitempty" db:"finished_at"`
+}
@@ -0,0 +1,20 @@
+package types
+
+type Organization struct {
+	OrgID     int    `json:"id" db:"org_id"`
+	Name      string `json:"name" db:"name"`
+	CreatedBy int    `json:"created_by" db:"created_by"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+	Role      string `json:"role,omitempty" db:"role"`
+}
+
+type OrgMember struct {
+	OrgID     int    `json:"org_id" db:"org_id"`
+	UserID    int    `json:"user_id" db:"user_id"`
+	Email     string `json:"email,omitempty" db:"email"`
+	FirstName string `json:"first_name" db:"first_name"`
+	LastName  string `json:"last_name" db:"last_name"`
+	Role      string `json:"role" db:"role"`
+	JoinedAt  string `json:"joined_at" db:"joined_at"`
+	Suspended bool   `json:"suspended,omitempty" db:"suspended"`
+}
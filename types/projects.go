@@ -0,0 +1,13 @@
+package types
+
+type Project struct {
+	ProjectID   int    `json:"id" db:"project_id"`
+	OrgID       int    `json:"org_id" db:"org_id"`
+	Name        string `json:"name" db:"name"`
+	Key         string `json:"key" db:"key"`
+	Description string `json:"description,omitempty" db:"description"`
+	OwnerID     int    `json:"owner_id" db:"owner_id"`
+	Archived    bool   `json:"archived" db:"archived"`
+	CreatedAt   string `json:"created_at" db:"created_at"`
+	UpdatedAt   string `json:"updated_at" db:"updated_at"`
+}
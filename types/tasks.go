@@ -8,25 +8,77 @@ const (
 	Done       TaskStatus = "Done"
 )
 
+func (s TaskStatus) IsValid() bool {
+	return s == ToDo || s == InProgress || s == Done
+}
+
 type TaskPriority string
 
+// DueDateLayout is the format of Task.DueDate.
+const DueDateLayout = "2006-01-02"
+
+// Sources of a task's effort estimate
+const (
+	EstimateUser = "user"
+	EstimateAI   = "ai"
+)
+
 const (
 	High   TaskPriority = "High"
 	Medium TaskPriority = "Medium"
 	Low    TaskPriority = "Low"
 )
 
+func (p TaskPriority) IsValid() bool {
+	return p == High || p == Medium || p == Low
+}
+
 type Task struct {
-	TaskID      int          `json:"id" db:"task_id"`
-	Title       string       `json:"title,omitempty" db:"title"`
-	Priority    TaskPriority `json:"priority,omitempty" db:"priority"`
-	Status      TaskStatus   `json:"status,omitempty" db:"status"`
-	AssignedTo  int          `json:"assigned_to,omitempty" db:"assigned_to"`
-	AssignedToName string	`json:"assigned_to_name,omitempty" db:"assigned_to_name"`
-	Description string       `json:"description,omitempty" db:"description"`
-	CreatedBy   int          `json:"created_by" db:"created_by"`
-	CreatedAt   string       `json:"created_at" db:"created_at"`
-	UpdatedAt   string       `json:"updated_at" db:"updated_at"`
+	TaskID         int          `json:"id" db:"task_id"`
+	OrgID          int          `json:"org_id,omitempty" db:"org_id"`
+	ProjectID      int          `json:"project_id,omitempty" db:"project_id"`
+	Key            string       `json:"key,omitempty" db:"task_key"`
+	Title          string       `json:"title,omitempty" db:"title"`
+	Priority       TaskPriority `json:"priority,omitempty" db:"priority"`
+	Status         TaskStatus   `json:"status,omitempty" db:"status"`
+	AssignedTo     int          `json:"assigned_to,omitempty" db:"assigned_to"`
+	AssignedToName string       `json:"assigned_to_name,omitempty" db:"assigned_to_name"`
+	Description    string       `json:"description,omitempty" db:"description"`
+	DueDate        string       `json:"due_date,omitempty" db:"due_date"` // YYYY-MM-DD
+	// Estimated effort in story points and/or hours, with a confidence
+	// between 0 and 1, nil when not given. The estimate is set and cleared
+	// as a whole; EstimateSource is EstimateUser or EstimateAI.
+	EstimatePoints     *float64 `json:"estimate_points,omitempty" db:"estimate_points"`
+	EstimateHours      *float64 `json:"estimate_hours,omitempty" db:"estimate_hours"`
+	EstimateConfidence *float64 `json:"estimate_confidence,omitempty" db:"estimate_confidence"`
+	EstimateSource     string   `json:"estimate_source,omitempty" db:"estimate_source"`
+	// Complexity is High, Medium or Low; only set on subtasks suggested by
+	// an analysis
+	Complexity string `json:"complexity,omitempty" db:"-"`
+	CreatedBy  int    `json:"created_by" db:"created_by"`
+	CreatedAt  string `json:"created_at" db:"created_at"`
+	UpdatedAt  string `json:"updated_at" db:"updated_at"`
+	// PossibleDuplicates is only filled in when a task is created
+	PossibleDuplicates []SimilarTask `json:"possible_duplicates,omitempty" db:"-"`
+}
+
+// SimilarTask is a task that reads like another one, with a cosine
+// similarity Score between 0 and 1.
+type SimilarTask struct {
+	TaskID int        `json:"id"`
+	Key    string     `json:"key,omitempty"`
+	Title  string     `json:"title"`
+	Status TaskStatus `json:"status"`
+	Score  float64    `json:"score"`
+}
+
+// TaskFilter narrows down task listings. Zero values are ignored.
+type TaskFilter struct {
+	ProjectID  int
+	Status     TaskStatus
+	Priority   TaskPriority
+	AssignedTo int
+	CreatedBy  int
 }
 
 type ChangeType string
@@ -58,18 +110,73 @@ type TaskSuggestion struct {
 	UserID         int    `json:"user_id" db:"user_id"`
 	SuggestionText string `json:"suggestion_text" db:"suggestion_text"`
 	SubTasks       []Task `json:"sub_tasks,omitempty"`
+	PromptVersion  string `json:"prompt_version,omitempty" db:"prompt_version"`
 	Accepted       bool   `json:"accepted" db:"accepted"`
 	CreatedAt      string `json:"created_at" db:"created_at"`
 }
 
+// Sources of a task recommendation
+const (
+	RecommendationAI        = "ai"
+	RecommendationHeuristic = "heuristic"
+)
+
+// Task fields a recommendation can be made for
+const (
+	RecommendPriority = "priority"
+	RecommendAssignee = "assignee"
+)
+
+// TaskRecommendation is a suggested priority and assignee for a task.
+// Once accepted, only the suggestions listed in Fields are applied.
+type TaskRecommendation struct {
+	RecommendationID int          `json:"id" db:"recommendation_id"`
+	TaskID           int          `json:"task_id" db:"task_id"`
+	UserID           int          `json:"user_id" db:"user_id"`
+	Priority         TaskPriority `json:"priority" db:"priority"`
+	AssigneeID       int          `json:"assignee_id,omitempty" db:"assignee_id"`
+	Fields           []string     `json:"fields" db:"fields"`
+	Rationale        string       `json:"rationale" db:"rationale"`
+	Source           string       `json:"source" db:"source"`
+	Accepted         bool         `json:"accepted" db:"accepted"`
+	CreatedAt        string       `json:"created_at" db:"created_at"`
+}
+
 type AITaskBreakdownRequest struct {
 	TaskID      int    `json:"task_id"`
 	Description string `json:"description"`
 	Context     string `json:"context,omitempty"`
+	// Stream sends the model's partial output over the websocket as it
+	// is produced
+	Stream bool `json:"stream,omitempty"`
+	// Force asks the model again even if an identical analysis is cached
+	Force bool `json:"force,omitempty"`
+}
+
+// ParseTaskRequest asks for a task to be drafted from free text.
+type ParseTaskRequest struct {
+	Text      string `json:"text"`
+	ProjectID int    `json:"project_id,omitempty"`
+	// Timezone is the IANA name used to resolve dates like "Friday",
+	// UTC by default
+	Timezone string `json:"timezone,omitempty"`
+	// Commit creates the task instead of only returning the draft
+	Commit bool `json:"commit"`
+}
+
+// AssigneeMatch reports how the person named in parsed text was matched
+// against workspace members.
+type AssigneeMatch struct {
+	Query      string       `json:"query"`
+	UserID     int          `json:"user_id,omitempty"`
+	Candidates []PublicUser `json:"candidates,omitempty"`
 }
 
 type AITaskBreakdownResponse struct {
 	TaskID      int              `json:"task_id"`
 	Suggestions []TaskSuggestion `json:"suggestions"`
 	Analysis    string           `json:"analysis"`
+	// Cached is set when the breakdown was served from the analysis cache
+	// instead of asking the model
+	Cached bool `json:"cached,omitempty"`
 }
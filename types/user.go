@@ -1,18 +1,50 @@
 package types
 
-
+// User is the private representation of an account. It is only returned to
+// the account owner and to admins of an organization the user belongs to.
 type User struct {
 	ID         int    `json:"id" db:"user_id"`
 	Email      string `json:"email" db:"email"`
-	Password   string `json:"password" db:"password"`
+	Password   string `json:"-" db:"password"`
 	FirstName  string `json:"first_name" db:"first_name"`
 	LastName   string `json:"last_name" db:"last_name"`
 	LoggedInAt string `json:"logged_in_at" db:"logged_in_at"`
 	CreatedAt  string `json:"created_at" db:"created_at"`
+
+	EmailVerified bool   `json:"email_verified" db:"email_verified"`
+	PendingEmail  string `json:"pending_email,omitempty" db:"pending_email"`
+	DeactivatedAt string `json:"deactivated_at,omitempty" db:"deactivated_at"`
+}
+
+// PublicUser holds the fields any member of a workspace may see about another
+// member, e.g. in assignee pickers.
+type PublicUser struct {
+	ID          int    `json:"id" db:"user_id"`
+	FirstName   string `json:"first_name" db:"first_name"`
+	LastName    string `json:"last_name" db:"last_name"`
+	Role        string `json:"role,omitempty" db:"role"`
+	Deactivated bool   `json:"deactivated,omitempty" db:"deactivated"`
+}
+
+// Public returns the workspace-visible part of u.
+func (u User) Public() PublicUser {
+	return PublicUser{
+		ID:          u.ID,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Deactivated: u.DeactivatedAt != "",
+	}
 }
 
 type SignInRequest struct {
 	ID       int    `json:"id" db:"user_id"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=6"`
+
+	Deactivated bool `json:"-" db:"deactivated"`
+}
+
+type Role struct {
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
 }